@@ -0,0 +1,128 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// AlbumInfo is the minimal view of an album needed to sync album ratings.
+// Albums have no path so they are paired by album artist and name instead.
+type AlbumInfo interface {
+	Id() string
+	AlbumArtist() string
+	Name() string
+	// FiveStarRating is the rating in the range 0-5, where 0 is unrated.
+	FiveStarRating() int
+}
+
+// AlbumLibrary may optionally be implemented by a Source that rates albums.
+type AlbumLibrary interface {
+	Albums(bar *pb.ProgressBar) ([]AlbumInfo, error)
+}
+
+// AlbumRatingSetter may optionally be implemented by a Destination able to
+// rate albums.
+type AlbumRatingSetter interface {
+	SetAlbumRating(id string, rating int) error
+}
+
+// AlbumPair is the same album as seen by the src and dst libraries. Src or
+// Dst is nil if the album is missing from that library.
+type AlbumPair struct {
+	Key string
+	Src AlbumInfo
+	Dst AlbumInfo
+}
+
+// AlbumKey returns the key used to pair an album.
+func AlbumKey(albumArtist, name string) string {
+	return strings.ToLower(strings.TrimSpace(albumArtist)) + " - " + strings.ToLower(strings.TrimSpace(name))
+}
+
+// PairAlbums pairs src and dst by album artist and name. The result is sorted
+// by Key.
+func PairAlbums(src, dst []AlbumInfo) []*AlbumPair {
+	var pairs []*AlbumPair
+	byKey := make(map[string]*AlbumPair)
+	get := func(k string) *AlbumPair {
+		t, ok := byKey[k]
+		if !ok {
+			t = &AlbumPair{Key: k}
+			byKey[k] = t
+			pairs = append(pairs, t)
+		}
+		return t
+	}
+	for _, a := range src {
+		get(AlbumKey(a.AlbumArtist(), a.Name())).Src = a
+	}
+	for _, a := range dst {
+		get(AlbumKey(a.AlbumArtist(), a.Name())).Dst = a
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs
+}
+
+// MissingRatedAlbums returns the src albums with a rating that aren't in dst.
+func MissingRatedAlbums(pairs []*AlbumPair) []*AlbumPair {
+	var r []*AlbumPair
+	for _, v := range pairs {
+		if v.Src != nil && v.Dst == nil && v.Src.FiveStarRating() > 0 {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// MismatchedAlbumRatings returns the matched pairs where dst should take the
+// src rating. Unless copyUnrated is set, an unrated src never clears dst.
+func MismatchedAlbumRatings(pairs []*AlbumPair, copyUnrated bool) []*AlbumPair {
+	var r []*AlbumPair
+	for _, v := range pairs {
+		if v.Src == nil || v.Dst == nil || v.Src.FiveStarRating() == v.Dst.FiveStarRating() {
+			continue
+		}
+		if v.Src.FiveStarRating() == 0 && !copyUnrated {
+			continue
+		}
+		r = append(r, v)
+	}
+	return r
+}
+
+// PrintMismatchedAlbumRatings writes the "Missing Rated Albums" and
+// "Mismatched Album Ratings" sections of the report.
+func PrintMismatchedAlbumRatings(w io.Writer, missing, mismatched []*AlbumPair) {
+	fmt.Fprintln(w, "== Missing Rated Albums ==")
+	for _, v := range missing {
+		fmt.Fprintf(w, "%s\n\trating src(%d)\n", v.Key, v.Src.FiveStarRating())
+	}
+	fmt.Fprintln(w, "")
+
+	fmt.Fprintln(w, "== Mismatched Album Ratings ==")
+	for _, v := range mismatched {
+		fmt.Fprintf(w, "%s\n\trating src(%d)\tdst(%d)\n", v.Key, v.Src.FiveStarRating(), v.Dst.FiveStarRating())
+	}
+	fmt.Fprintln(w, "")
+}
+
+// CopyAlbumRatings sets the src rating on dst for each of pairs.
+func CopyAlbumRatings(dst AlbumRatingSetter, pairs []*AlbumPair, skip *SkipCounter) error {
+	bar := PbWithOptions(pb.Default(int64(len(pairs)), "set album rating"))
+	defer bar.Finish()
+	for _, v := range pairs {
+		err := dst.SetAlbumRating(v.Dst.Id(), v.Src.FiveStarRating())
+		bar.Add(1)
+		if err != nil {
+			if err := skip.Skip(v.Key, "album rating", err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package itunes2subsonic
+
+import (
+	"testing"
+
+	"github.com/logank/itunes2subsonic/internal/itunes"
+)
+
+func TestItunesAlbums(t *testing.T) {
+	l := &ItunesLibrary{Library: &itunes.Library{Tracks: map[string]itunes.Track{
+		"1": {Artist: "Rush", Album: "2112", AlbumRating: 80},
+		"2": {Artist: "Rush", Album: "2112", AlbumRating: 80},
+		"3": {Artist: "Geddy Lee", AlbumArtist: "Rush", Album: "Moving Pictures", AlbumRating: 60, AlbumRatingComputed: true},
+		"4": {Artist: "Rush", Album: ""},
+	}}}
+
+	albums, err := l.Albums(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int)
+	for _, a := range albums {
+		got[AlbumKey(a.AlbumArtist(), a.Name())] = a.FiveStarRating()
+	}
+	want := map[string]int{"rush - 2112": 4, "rush - moving pictures": 0}
+	if len(got) != len(want) {
+		t.Fatalf("Albums() = %v, want %v", got, want)
+	}
+	for k, r := range want {
+		if got[k] != r {
+			t.Errorf("Albums() rating for %s = %d, want %d", k, got[k], r)
+		}
+	}
+}
+
+func TestMismatchedAlbumRatings(t *testing.T) {
+	src := []AlbumInfo{
+		&itunesAlbum{"Rush", "2112", 4},
+		&itunesAlbum{"Rush", "Moving Pictures", 0},
+		&itunesAlbum{"Rush", "Signals", 3},
+		&itunesAlbum{"Rush", "Caress of Steel", 5},
+	}
+	dst := []AlbumInfo{
+		&subsonicAlbum{UserRating: 2},
+		&subsonicAlbum{UserRating: 1},
+		&subsonicAlbum{UserRating: 3},
+	}
+	for i, n := range []string{"2112", "MOVING PICTURES", "Signals "} {
+		dst[i].(*subsonicAlbum).ID = []string{"a", "b", "c"}[i]
+		dst[i].(*subsonicAlbum).Artist = "rush"
+		dst[i].(*subsonicAlbum).AlbumID3.Name = n
+	}
+	pairs := PairAlbums(src, dst)
+
+	if got := MissingRatedAlbums(pairs); len(got) != 1 || got[0].Key != "rush - caress of steel" {
+		t.Errorf("MissingRatedAlbums() = %v, want only caress of steel", got)
+	}
+	if got := MismatchedAlbumRatings(pairs, false); len(got) != 1 || got[0].Dst.Id() != "a" {
+		t.Errorf("MismatchedAlbumRatings(false) = %v, want only dst a", got)
+	}
+	if got := MismatchedAlbumRatings(pairs, true); len(got) != 2 {
+		t.Errorf("MismatchedAlbumRatings(true) len = %d, want 2", len(got))
+	}
+}
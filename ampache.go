@@ -0,0 +1,107 @@
+package itunes2subsonic
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/logank/ampache"
+	pb "github.com/schollz/progressbar/v3"
+)
+
+type ampacheSong struct {
+	s ampache.Song
+}
+
+func (s ampacheSong) Id() string          { return strconv.Itoa(s.s.Id) }
+func (s ampacheSong) Path() string        { return s.s.Filename }
+func (s ampacheSong) FiveStarRating() int { return s.s.Rating }
+
+func (s ampacheSong) Title() string           { return s.s.Title }
+func (s ampacheSong) Artist() string          { return s.s.Artist.Name }
+func (s ampacheSong) AlbumArtist() string     { return s.s.AlbumArtist.Name }
+func (s ampacheSong) Album() string           { return s.s.Album.Name }
+func (s ampacheSong) TrackNumber() int        { return s.s.Track }
+func (s ampacheSong) DiscNumber() int         { return s.s.Disk }
+func (s ampacheSong) Duration() time.Duration { return s.s.Time.Duration }
+func (s ampacheSong) Size() int64             { return int64(s.s.Size) }
+
+func (s ampacheSong) Starred() bool { return s.s.Flag != 0 }
+
+func (s ampacheSong) MusicBrainzId() string { return s.s.Mbid }
+
+// AmpacheLibrary is a Source and Destination backed by the Ampache XML API.
+type AmpacheLibrary struct {
+	*ampache.Client
+	url string
+}
+
+// NewAmpacheLibrary wraps an authenticated client connected to url.
+func NewAmpacheLibrary(c *ampache.Client, url string) *AmpacheLibrary {
+	return &AmpacheLibrary{Client: c, url: url}
+}
+
+func (l *AmpacheLibrary) Name() string { return "ampache:" + l.url }
+
+func (l *AmpacheLibrary) Songs(bar *pb.ProgressBar) ([]SongInfo, error) {
+	var tracks []SongInfo
+
+	offset := 0
+	for {
+		songs, err := l.Client.Songs(map[string]string{"limit": "400", "offset": strconv.Itoa(offset)})
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching Ampache songs: %w", err)
+		}
+
+		for _, s := range songs.Songs {
+			tracks = append(tracks, ampacheSong{s})
+		}
+
+		if len(songs.Songs) == 0 {
+			break
+		}
+
+		offset += len(songs.Songs)
+		if bar != nil {
+			bar.Add(len(songs.Songs))
+		}
+	}
+
+	return tracks, nil
+}
+
+func (l *AmpacheLibrary) SetRating(id string, rating int) error {
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("unexpected Ampache ID '%s': %w", id, err)
+	}
+	_, err = l.Rate(ampache.MediaSong, i, rating)
+	return err
+}
+
+// SetStarred flags or unflags the song as a favorite.
+func (l *AmpacheLibrary) SetStarred(id string, starred bool) error {
+	flag := "0"
+	if starred {
+		flag = "1"
+	}
+	resp, err := l.Invoke("flag", map[string]string{
+		"type": ampache.MediaSong,
+		"id":   id,
+		"flag": flag,
+	})
+	if err != nil {
+		return fmt.Errorf("flag failure: %w", err)
+	}
+	defer resp.Close()
+
+	var v ampache.Response
+	if err := xml.NewDecoder(resp).Decode(&v); err != nil {
+		return fmt.Errorf("unexpected flag response: %w", err)
+	}
+	if v.Error != nil {
+		return v.Error
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package itunes2subsonic
+
+import (
+	"time"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// Source is a music library that songs can be read from.
+type Source interface {
+	// Name identifies the library in reports, e.g., "subsonic:https://example.com".
+	Name() string
+	// Songs fetches every song in the library. bar, if given, is advanced as
+	// songs arrive.
+	Songs(bar *pb.ProgressBar) ([]SongInfo, error)
+}
+
+// RatingSetter is implemented by clients able to set a song's rating.
+type RatingSetter interface {
+	// SetRating sets the rating of the song with the given ID in the range
+	// 0-5, where 0 removes the rating.
+	SetRating(id string, rating int) error
+}
+
+// Destination is a music library that can be updated to match a Source.
+type Destination interface {
+	Source
+	RatingSetter
+}
+
+// PlayRecorder may optionally be implemented by a Destination able to record
+// when a song was played. Each call counts as one play.
+type PlayRecorder interface {
+	// RecordPlay records a play at t, or now if t is zero.
+	RecordPlay(id string, t time.Time) error
+}
+
+// Starrer may optionally be implemented by a Destination able to mark songs as
+// favorites.
+type Starrer interface {
+	SetStarred(id string, starred bool) error
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/delucks/go-subsonic"
+	"github.com/logank/ampache"
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+const backendHelp = `  itunes:<path>    an iTunes XML library export (read only)
+  subsonic:<url>   a Subsonic API server. Credentials are read from
+                   SUBSONIC_SRC_USER/SUBSONIC_SRC_PASS for --from and
+                   SUBSONIC_USER/SUBSONIC_PASS for --to
+  ampache:<url>    an Ampache server. Credentials are read from
+                   AMPACHE_SRC_USER/AMPACHE_SRC_PASS for --from and
+                   AMPACHE_USER/AMPACHE_PASS for --to
+`
+
+// splitSpec splits a library spec like "subsonic:https://example.com".
+func splitSpec(spec string) (string, string, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 || i == len(spec)-1 {
+		return "", "", fmt.Errorf("library '%s' should be of the form kind:location", spec)
+	}
+	return spec[:i], spec[i+1:], nil
+}
+
+// credentials reads the user and password for kind. src selects the
+// *_SRC_USER variables so a server can be both the source and destination.
+func credentials(kind string, src bool) (string, string, error) {
+	prefix := strings.ToUpper(kind) + "_"
+	if src {
+		prefix += "SRC_"
+	}
+	user, pass := os.Getenv(prefix+"USER"), os.Getenv(prefix+"PASS")
+	if user == "" || pass == "" {
+		return "", "", fmt.Errorf("you must set the %sUSER and %sPASS environment variables", prefix, prefix)
+	}
+	return user, pass, nil
+}
+
+func openSubsonic(url string, src bool) (*i2s.SubsonicLibrary, error) {
+	user, pass, err := credentials("subsonic", src)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sources log in with the password, as subsonic2subsonic does, so
+	// servers that don't support token auth can still be read from.
+	c := &subsonic.Client{
+		Client:       &http.Client{},
+		BaseUrl:      url,
+		User:         user,
+		PasswordAuth: src,
+		ClientName:   "itunes2subsonic",
+	}
+	if err := c.Authenticate(pass); err != nil {
+		return nil, fmt.Errorf("failed to create Subsonic client: %w", err)
+	}
+	return i2s.NewSubsonicLibrary(c), nil
+}
+
+func openAmpache(url string, src bool) (*i2s.AmpacheLibrary, error) {
+	user, pass, err := credentials("ampache", src)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ampache.New(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Ampache client: %w", err)
+	}
+	c.WithAuthPassword(user, pass)
+
+	if v, found := os.LookupEnv("AMPACHE_VERBOSE"); found {
+		if vi, err := strconv.Atoi(v); err == nil {
+			c.Verbose = vi
+		}
+	}
+	return i2s.NewAmpacheLibrary(c, url), nil
+}
+
+// openSource opens the library described by spec for reading.
+func openSource(spec string) (i2s.Source, error) {
+	kind, loc, err := splitSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "itunes":
+		return i2s.OpenItunesLibrary(loc)
+	case "subsonic":
+		return openSubsonic(loc, true)
+	case "ampache":
+		return openAmpache(loc, true)
+	}
+	return nil, fmt.Errorf("unknown library kind '%s'", kind)
+}
+
+// openDestination opens the library described by spec for writing.
+func openDestination(spec string) (i2s.Destination, error) {
+	return openWritable(spec, false)
+}
+
+// openWritable opens the library described by spec for writing, with the
+// credentials for --from if src is set.
+func openWritable(spec string, src bool) (i2s.Destination, error) {
+	kind, loc, err := splitSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "itunes":
+		return nil, errors.New("iTunes libraries cannot be written to")
+	case "subsonic":
+		return openSubsonic(loc, src)
+	case "ampache":
+		return openAmpache(loc, src)
+	}
+	return nil, fmt.Errorf("unknown library kind '%s'", kind)
+}
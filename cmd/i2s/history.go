@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	stateDb := fs.String("state_db", "i2s.db", "the database given to sync --state_db")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*stateDb); err != nil {
+		return err
+	}
+	db, err := i2s.OpenStateDb(*stateDb, "", "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runs, err := db.Runs()
+	if err != nil {
+		return err
+	}
+	i2s.PrintRuns(os.Stdout, runs)
+	return nil
+}
@@ -0,0 +1,60 @@
+// Command i2s syncs ratings between any pair of supported music libraries.
+//
+// Usage:
+//
+//	i2s sync --from=itunes:"iTunes Music Library.xml" --to=subsonic:https://subsonic.example.com
+//	i2s smart --from=itunes:"iTunes Music Library.xml" --out=/music/playlists
+//	i2s history --state_db=i2s.db
+//	i2s undo --state_db=i2s.db --run=3
+//	i2s restore --snapshot=i2s_backups/subsonic_https_subsonic.example.com-20240101T000000Z.json
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+type command struct {
+	help string
+	run  func(args []string) error
+}
+
+var commands = map[string]command{
+	"sync":    {"copy ratings from one library to another", runSync},
+	"smart":   {"translate iTunes smart playlists to Navidrome .nsp files", runSmart},
+	"history": {"list the runs recorded by sync --state_db", runHistory},
+	"undo":    {"restore the ratings, album ratings and stars changed by a sync run", runUndo},
+	"restore": {"replay a snapshot saved by sync --backup_dir onto a library", runRestore},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	var names []string
+	for k := range commands {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", k, commands[k].help)
+	}
+	fmt.Fprintf(os.Stderr, "\nLibraries are given as kind:location, one of:\n%s", backendHelp)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		log.Fatalf("%s failed: %s", os.Args[1], err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := fs.String("snapshot", "", "a snapshot saved by sync --backup_dir")
+	to := fs.String("to", "", "(optional) the library to restore, the one the snapshot was taken of by default")
+	// A snapshot is restored by syncing it to the library, so its ratings,
+	// stars and plays are copied whatever they are.
+	opts := i2s.SyncOptions{
+		CopyUnrated:     true,
+		CopyLoved:       true,
+		CopyUnloved:     true,
+		UpdatePlayed:    true,
+		UpdatePlayCount: true,
+		Conflicts:       i2s.ConflictSrc,
+		Collisions:      i2s.CollisionRating,
+		Report:          i2s.ReportText,
+		RootFlags:       "--map",
+	}
+	fs.BoolVar(&opts.DryRun, "dry_run", true, "don't modify the library")
+	fs.IntVar(&opts.SkipCount, "skip_count", 10, "a limit on the number of tracks that would be skipped before refusing to process")
+	fs.Var(&opts.RootMaps, "map", "(optional) a snapshot library prefix and the --to prefix of the same folder. Repeatable")
+	fs.StringVar(&opts.StateDb, "state_db", "i2s.db", "an SQLite database recording the restore, as for sync. Empty to keep no record")
+	fs.StringVar(&opts.BackupDir, "backup_dir", "i2s_backups", "where to save a snapshot of --to before restoring. Empty to save none")
+	fs.StringVar(&opts.BackupFormat, "backup_format", i2s.SnapshotJson, "the format of the --backup_dir snapshot: 'json' or 'csv'")
+	fs.Parse(args)
+
+	if *path == "" {
+		return errors.New("you must provide --snapshot")
+	}
+	snapshot, err := i2s.LoadSnapshot(*path)
+	if err != nil {
+		return err
+	}
+	if *to == "" {
+		*to = snapshot.Library
+	}
+	if *to == "" {
+		return errors.New("the snapshot doesn't name its library, you must provide --to")
+	}
+	dst, err := openDestination(*to)
+	if err != nil {
+		return err
+	}
+	// Onto the library it was taken of, the songs keep their IDs whatever
+	// their paths.
+	opts.PairById = dst.Name() == snapshot.Library
+
+	_, err = i2s.Sync(opts.TextOutput(), snapshot, dst, opts)
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+func runSmart(args []string) error {
+	fs := flag.NewFlagSet("smart", flag.ExitOnError)
+	from := fs.String("from", "", "the iTunes library to read, e.g., itunes:library.xml")
+	out := fs.String("out", "", "the directory to write .nsp files to, e.g., Navidrome's playlists folder")
+	names := fs.String("playlists", "", "comma-separated names of smart playlists to translate. All if empty")
+	var opts i2s.SmartPlaylistOptions
+	fs.BoolVar(&opts.AllowPartial, "allow_partial", false, "write playlists even if some criteria can't be translated")
+	fs.Parse(args)
+
+	if *from == "" || *out == "" {
+		return errors.New("you must provide both --from and --out")
+	}
+	kind, location, err := splitSpec(*from)
+	if err != nil {
+		return err
+	}
+	if kind != "itunes" {
+		return fmt.Errorf("smart playlists can only be read from itunes, not '%s'", kind)
+	}
+
+	l, err := i2s.OpenItunesLibrary(location)
+	if err != nil {
+		return fmt.Errorf("failed to load iTunes library: %w", err)
+	}
+	opts.Dir = *out
+	if *names != "" {
+		opts.Names = strings.Split(*names, ",")
+	}
+	return i2s.WriteSmartPlaylists(os.Stdout, l, opts)
+}
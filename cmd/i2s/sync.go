@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	from := fs.String("from", "", "the library to read, e.g., itunes:library.xml")
+	to := fs.String("to", "", "the library to write to, e.g., subsonic:https://subsonic.example.com")
+	opts := i2s.SyncOptions{RootFlags: "--src_root and --dst_root"}
+	opts.RegisterFlags(fs)
+	var navidrome i2s.NavidromeOptions
+	navidrome.RegisterFlags(fs)
+	fs.StringVar(&opts.SrcRoot, "src_root", "", "(optional) library prefix for --from content")
+	fs.StringVar(&opts.DstRoot, "dst_root", "", "(optional) library prefix for --to content")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		return errors.New("you must provide both --from and --to")
+	}
+	if kind, _, _ := splitSpec(*to); navidrome.Enabled() && kind != "subsonic" {
+		return errors.New("the --navidrome_* flags need a subsonic --to")
+	}
+
+	src, err := openSource(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := openDestination(*to)
+	if err != nil {
+		return err
+	}
+
+	out := opts.TextOutput()
+	pairing, err := i2s.Sync(out, src, dst, opts)
+	if err != nil {
+		return err
+	}
+
+	navidrome.User = os.Getenv("SUBSONIC_USER")
+	return navidrome.Run(out, pairing)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+func runUndo(args []string) error {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	stateDb := fs.String("state_db", "i2s.db", "the database given to sync --state_db")
+	run := fs.Int64("run", 0, "the ID of the run to undo, as listed by history")
+	dryRun := fs.Bool("dry_run", true, "don't modify the libraries")
+	skipCount := fs.Int("skip_count", 10, "a limit on the number of tracks that would be skipped before refusing to process")
+	fs.Parse(args)
+
+	if *run == 0 {
+		return errors.New("you must provide --run")
+	}
+	if _, err := os.Stat(*stateDb); err != nil {
+		return err
+	}
+	open := i2s.OpenStateDb
+	if *dryRun {
+		open = i2s.OpenStateDbReadOnly
+	}
+	db, err := open(*stateDb, "", "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Library names are the specs they were opened with.
+	return i2s.UndoRun(os.Stdout, db, *run, openWritable, *dryRun, *skipCount)
+}
@@ -0,0 +1,97 @@
+package main
+
+// Notes:
+// -   Normalizes paths to lower case because iTunes/Windows doesn't update if the underlying file changes.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/logank/ampache"
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+var (
+	itunesXml  = flag.String("itunes_xml", "", "path to the itunes XML to import")
+	ampacheUrl = flag.String("ampache", "", "url of the Ampache instance")
+	playFile   = flag.String("play_file", "", "a file to write Ampache SQL statements to update Last Played")
+
+	opts = i2s.SyncOptions{RootFlags: "--itunes_root and --ampache_root"}
+)
+
+func init() {
+	opts.RegisterFlags(flag.CommandLine)
+	flag.BoolVar(&opts.DryRun, "n", true, "don't modify the library")
+	flag.StringVar(&opts.SrcRoot, "itunes_root", "", "(optional) library prefix for iTunes content")
+	flag.StringVar(&opts.DstRoot, "ampache_root", "", "(optional) library prefix for Ampache content")
+}
+
+func writePlayedSql(f io.Writer, pairs []*i2s.SongPair) error {
+	fmt.Fprint(f, "# SET @USER_ID := 2;\n")
+	for _, v := range pairs {
+		played, ok := v.Src.(i2s.SongPlays)
+		if !ok || played.LastPlayed().IsZero() {
+			continue
+		}
+		id, err := i2s.SqlQuote(v.Dst.Id())
+		if err != nil {
+			return fmt.Errorf("bad ID for '%s': %w", v.Key, err)
+		}
+
+		fmt.Fprintf(f, "INSERT INTO user_activity (user, action, object_type, object_id, activity_date) VALUES (@USER_ID, 'play', 'song', %s, %d);\n", id, played.LastPlayed().Unix())
+		fmt.Fprintf(f, "INSERT INTO object_count (user, count_type, object_type, object_id, date, agent) VALUES (@USER_ID, 'stream', 'song', %s, %d, 'itunes2ampache');\n", id, played.LastPlayed().Unix())
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+	ampacheUser, ampachePass := os.Getenv("AMPACHE_USER"), os.Getenv("AMPACHE_PASS")
+
+	if *itunesXml == "" || *ampacheUrl == "" {
+		log.Fatal("You must provide both --itunes_xml and --ampache")
+	}
+	if ampacheUser == "" || ampachePass == "" {
+		log.Fatal("You must set the AMPACHE_USER and AMPACHE_PASS environment variables.")
+	}
+
+	src, err := i2s.OpenItunesLibrary(*itunesXml)
+	if err != nil {
+		log.Fatalf("failed to read --itunes_xml=%s: %s", *itunesXml, err)
+	}
+
+	c, err := ampache.New(*ampacheUrl)
+	if err != nil {
+		log.Fatalf("Failed to create Ampache client: %s", err)
+	}
+	c.WithAuthPassword(ampacheUser, ampachePass)
+
+	if v, found := os.LookupEnv("AMPACHE_VERBOSE"); found {
+		if vi, err := strconv.Atoi(v); err == nil {
+			c.Verbose = vi
+		}
+	}
+
+	pairing, err := i2s.Sync(opts.TextOutput(), src, i2s.NewAmpacheLibrary(c, *ampacheUrl), opts)
+	if err != nil {
+		log.Fatalf("Failed to sync: %s", err)
+	}
+
+	if *playFile != "" {
+		f, err := os.OpenFile(*playFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open given play file: %s", err)
+		}
+		defer f.Close()
+
+		err = writePlayedSql(f, pairing.Matched())
+		if err != nil {
+			log.Fatalf("Failed to write play file: %s", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+// Notes:
+// -   Normalizes paths to lower case because iTunes/Windows doesn't update if the underlying file changes.
+// -   Navidrome requires going into the Player settings and configuring "Report Real Path"
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/delucks/go-subsonic"
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+var (
+	itunesXml   = flag.String("itunes_xml", "iTunes Music Library.xml", "path to the itunes XML to import")
+	subsonicUrl = flag.String("subsonic", "", "url of the Subsonic instance")
+
+	opts      = i2s.SyncOptions{RootFlags: "--itunes_root and --subsonic_root"}
+	navidrome i2s.NavidromeOptions
+)
+
+func init() {
+	opts.RegisterFlags(flag.CommandLine)
+	navidrome.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&opts.SrcRoot, "itunes_root", "", "(optional) library prefix for iTunes content")
+	flag.StringVar(&opts.DstRoot, "subsonic_root", "", "(optional) library prefix for Subsonic content")
+}
+
+func main() {
+	flag.Parse()
+	subsonicUser, subsonicPass := os.Getenv("SUBSONIC_USER"), os.Getenv("SUBSONIC_PASS")
+
+	if (subsonicUser != "" || *subsonicUrl != "") && subsonicPass == "" {
+		log.Fatal("If connecting to Subsonic, you must set the SUBSONIC_USER and SUBSONIC_PASS environment variables.")
+	}
+
+	src, err := i2s.OpenItunesLibrary(*itunesXml)
+	if err != nil {
+		log.Fatalf("failed to read --itunes_xml=%s: %s", *itunesXml, err)
+	}
+
+	c := &subsonic.Client{
+		Client:     &http.Client{},
+		BaseUrl:    *subsonicUrl,
+		User:       subsonicUser,
+		ClientName: "itunes2subsonic",
+	}
+	if err := c.Authenticate(subsonicPass); err != nil {
+		log.Fatalf("Failed to create Subsonic client: %s", err)
+	}
+
+	out := opts.TextOutput()
+	pairing, err := i2s.Sync(out, src, i2s.NewSubsonicLibrary(c), opts)
+	if err != nil {
+		log.Fatalf("Failed to sync: %s", err)
+	}
+
+	navidrome.User = subsonicUser
+	if err := navidrome.Run(out, pairing); err != nil {
+		log.Fatalf("Failed Navidrome export: %s", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+// Notes:
+// -   Normalizes paths to lower case because iTunes/Windows doesn't update if the underlying file changes.
+// -   Navidrome requires going into the Player settings and configuring "Report Real Path"
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/delucks/go-subsonic"
+	i2s "github.com/logank/itunes2subsonic"
+)
+
+var (
+	subsonicSrcUrl = flag.String("subsonic_src", "", "url of the Subsonic instance to read")
+	subsonicDstUrl = flag.String("subsonic_dst", "", "url of the Subsonic instance to write to")
+
+	opts = i2s.SyncOptions{RootFlags: "--subsonic_src_root and --subsonic_dst_root"}
+)
+
+func init() {
+	opts.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&opts.SrcRoot, "subsonic_src_root", "", "(optional) the music library prefix on the read instance")
+	flag.StringVar(&opts.DstRoot, "subsonic_dst_root", "", "(optional) the music library prefix on the write instance")
+}
+
+func main() {
+	flag.Parse()
+	subsonicSrcUser, subsonicSrcPass := os.Getenv("SUBSONIC_SRC_USER"), os.Getenv("SUBSONIC_SRC_PASS")
+	subsonicDstUser, subsonicDstPass := os.Getenv("SUBSONIC_USER"), os.Getenv("SUBSONIC_PASS")
+
+	if *subsonicSrcUrl == "" || *subsonicDstUrl == "" {
+		log.Fatal("You must provide both --subsonic_src and --subsonic_dst")
+	}
+
+	if subsonicSrcUser == "" || subsonicSrcPass == "" {
+		log.Fatal("You must set the SUBSONIC_SRC_USER and SUBSONIC_SRC_PASS environment variables.")
+	}
+	if subsonicDstUser == "" || subsonicDstPass == "" {
+		log.Fatal("You must set the SUBSONIC_USER and SUBSONIC_PASS environment variables.")
+	}
+
+	srcC := &subsonic.Client{
+		Client:       &http.Client{},
+		BaseUrl:      *subsonicSrcUrl,
+		User:         subsonicSrcUser,
+		PasswordAuth: true,
+		ClientName:   "subsonic2subsonic",
+	}
+	if err := srcC.Authenticate(subsonicSrcPass); err != nil {
+		log.Fatalf("Failed to create Subsonic client: %s", err)
+	}
+
+	dstC := &subsonic.Client{
+		Client:     &http.Client{},
+		BaseUrl:    *subsonicDstUrl,
+		User:       subsonicDstUser,
+		ClientName: "subsonic2subsonic",
+	}
+	if err := dstC.Authenticate(subsonicDstPass); err != nil {
+		log.Fatalf("Failed to create Subsonic client: %s", err)
+	}
+
+	src, dst := i2s.NewSubsonicLibrary(srcC), i2s.NewSubsonicLibrary(dstC)
+	if _, err := i2s.Sync(opts.TextOutput(), src, dst, opts); err != nil {
+		log.Fatalf("Failed to sync: %s", err)
+	}
+}
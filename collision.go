@@ -0,0 +1,97 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The policies for choosing between songs in one library with the same key,
+// as set in PairingOptions.Collisions.
+const (
+	// CollisionRating keeps the highest rated song.
+	CollisionRating = "rating"
+	// CollisionModified keeps the most recently modified song.
+	CollisionModified = "modified"
+	// CollisionSkip keeps none of them, leaving the key out of the sync.
+	CollisionSkip = "skip"
+)
+
+// ValidCollisionPolicy returns whether policy is one of the Collision
+// policies.
+func ValidCollisionPolicy(policy string) bool {
+	switch policy {
+	case CollisionRating, CollisionModified, CollisionSkip:
+		return true
+	}
+	return false
+}
+
+// Collision is several songs of one library with the same key, e.g., two
+// iTunes tracks for the same file.
+type Collision struct {
+	Key string
+	// Dst is set if the songs are in dst, otherwise they're in src.
+	Dst bool
+	// Songs are ordered by the policy, best first.
+	Songs []SongInfo
+	// Kept is the song paired, or nil if skipped.
+	Kept SongInfo
+}
+
+// dateModified returns when s was last modified, or zero if unknown.
+func dateModified(s SongInfo) time.Time {
+	if m, ok := s.(SongModified); ok {
+		return m.DateModified()
+	}
+	return time.Time{}
+}
+
+// resolveCollision orders songs, which share key k, by policy and picks the
+// one to keep. Ties are broken by the other criteria, then by ID, so the
+// library order never decides.
+func resolveCollision(k string, songs []SongInfo, dst bool, policy string) *Collision {
+	c := &Collision{Key: k, Dst: dst, Songs: append([]SongInfo(nil), songs...)}
+	sort.SliceStable(c.Songs, func(i, j int) bool {
+		a, b := c.Songs[i], c.Songs[j]
+		ra, rb := a.FiveStarRating(), b.FiveStarRating()
+		ma, mb := dateModified(a), dateModified(b)
+		if policy == CollisionModified && !ma.Equal(mb) {
+			return ma.After(mb)
+		}
+		if ra != rb {
+			return ra > rb
+		}
+		if !ma.Equal(mb) {
+			return ma.After(mb)
+		}
+		return a.Id() < b.Id()
+	})
+	if policy != CollisionSkip {
+		c.Kept = c.Songs[0]
+	}
+	return c
+}
+
+// PrintCollisions writes the "Path Collisions" section of the report.
+func PrintCollisions(w io.Writer, p *Pairing) {
+	fmt.Fprintln(w, "== Path Collisions ==")
+	for _, c := range p.Collisions {
+		side := "src"
+		if c.Dst {
+			side = "dst"
+		}
+		var ids []string
+		for _, s := range c.Songs {
+			ids = append(ids, s.Id())
+		}
+		kept := "none, skipped"
+		if c.Kept != nil {
+			kept = c.Kept.Id()
+		}
+		fmt.Fprintf(w, "%s\n\t%s songs(%s)\tkept(%s)\n", c.Key, side, strings.Join(ids, ", "), kept)
+	}
+	fmt.Fprintln(w, "")
+}
@@ -0,0 +1,71 @@
+package itunes2subsonic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testModifiedSong struct {
+	testSong
+	modified time.Time
+}
+
+func (s testModifiedSong) DateModified() time.Time { return s.modified }
+
+func TestNewPairingCollisions(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := []SongInfo{
+		testModifiedSong{testSong{"1", "/a/Rush/2112.mp3", 3}, old.Add(time.Hour)},
+		testModifiedSong{testSong{"2", "/a/RUSH/2112.mp3", 5}, old},
+		testModifiedSong{testSong{"3", "/a/Rush/Tears.mp3", 4}, old},
+		// Tied on both, so the ID decides.
+		testModifiedSong{testSong{"5", "/a/Rush/Lessons.mp3", 2}, old},
+		testModifiedSong{testSong{"4", "/a/rush/lessons.mp3", 2}, old},
+	}
+	dst := []SongInfo{
+		testSong{"a", "/b/Rush/2112.mp3", 0},
+		testSong{"b", "/b/Rush/Tears.mp3", 0},
+		testSong{"c", "/b/rush/tears.mp3", 0},
+		testSong{"d", "/b/Rush/Lessons.mp3", 0},
+	}
+
+	tests := []struct {
+		policy string
+		// want maps each dst song to the src song it's paired with.
+		want map[string]string
+	}{
+		{CollisionRating, map[string]string{"a": "2", "b": "3", "d": "4"}},
+		{CollisionModified, map[string]string{"a": "1", "b": "3", "d": "4"}},
+		{CollisionSkip, map[string]string{}},
+	}
+	for _, test := range tests {
+		p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}, Collisions: test.policy})
+		got := make(map[string]string)
+		for _, v := range p.Matched() {
+			got[v.Dst.Id()] = v.Src.Id()
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: Matched() = %v, want %v", test.policy, got, test.want)
+		}
+		for k, v := range test.want {
+			if got[k] != v {
+				t.Errorf("%s: Matched() dst %s = %s, want %s", test.policy, k, got[k], v)
+			}
+		}
+
+		if len(p.Collisions) != 3 {
+			t.Fatalf("%s: Collisions len = %d, want 3", test.policy, len(p.Collisions))
+		}
+		if c := p.Collisions[2]; c.Key != "rush/tears.mp3" || !c.Dst || len(c.Songs) != 2 {
+			t.Errorf("%s: Collisions[2] = %+v, want dst rush/tears.mp3", test.policy, c)
+		}
+	}
+
+	var b bytes.Buffer
+	PrintCollisions(&b, NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}}))
+	if s := b.String(); !strings.Contains(s, "rush/2112.mp3\n\tsrc songs(2, 1)\tkept(2)\n") {
+		t.Errorf("PrintCollisions() = %s", s)
+	}
+}
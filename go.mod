@@ -1,11 +1,33 @@
 module github.com/logank/itunes2subsonic
 
-go 1.13
+go 1.20
 
 require (
-	github.com/delucks/go-subsonic v0.0.0-20220915164742-2744002c4be5 // indirect
+	github.com/delucks/go-subsonic v0.0.0-20220915164742-2744002c4be5
 	github.com/logank/ampache v0.9.1
-	github.com/schollz/progressbar/v3 v3.12.2 // indirect
-	golang.org/x/sync v0.1.0 // indirect
+	github.com/schollz/progressbar/v3 v3.12.2
+	golang.org/x/sync v0.6.0
+	golang.org/x/text v0.14.0
 	howett.net/plist v1.0.0
+	modernc.org/sqlite v1.29.0
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	github.com/mattn/go-runewidth v0.0.14 // indirect
+	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	github.com/rivo/uniseg v0.4.3 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/term v0.3.0 // indirect
+	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
+	modernc.org/libc v1.41.0 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.7.2 // indirect
+	modernc.org/strutil v1.2.0 // indirect
+	modernc.org/token v1.1.0 // indirect
 )
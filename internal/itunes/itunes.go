@@ -77,6 +77,7 @@ type Playlist struct {
 	PlaylistPersistentId string `plist:"Playlist Persistent ID"`
 	DistinguishedKind    int    `plist:"Distinguished Kind"`
 	Visible              bool
+	Folder               bool
 	AllItems             bool           `plist:"All Items"`
 	SmartInfo            []byte         `plist:"Smart Info"`
 	SmartCriteria        []byte         `plist:"Smart Criteria"`
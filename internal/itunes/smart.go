@@ -0,0 +1,412 @@
+package itunes
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// The Smart Info and Smart Criteria blobs are undocumented. The layout here
+// follows what other iTunes importers have reverse engineered.
+//
+// Smart Info:
+//
+//	0      live updating
+//	1      match the rules (otherwise only the limit applies)
+//	2      limited
+//	3      limit unit
+//	7      limit selection method
+//	8-11   limit
+//	12     match only checked items
+//	13     selection method is reversed, e.g., "least" rather than "most"
+//
+// Smart Criteria is an "SLst" rule group:
+//
+//	0-3    "SLst"
+//	8-11   rule count
+//	15     1 if any rule may match, 0 if all must
+//	136-   rules
+//
+// Each rule is a 56 byte header followed by its value:
+//
+//	0-3    field
+//	4      operator sign: bit 0 for strings, bit 1 for negation
+//	5-7    operator
+//	52-55  value length
+//
+// String values are UTF-16BE. Other values are 68 bytes, of which the first
+// 32 are four big-endian int64s: the value, the "in the last" amount
+// (negated), the "in the last" unit in seconds and the top of a range.
+const (
+	smartInfoLen    = 14
+	groupHeaderLen  = 136
+	ruleHeaderLen   = 56
+	intValueMinLen  = 32
+	groupMagic      = "SLst"
+	maxNestedGroups = 16
+)
+
+// Field is what a rule matches against, e.g., Artist.
+type Field uint32
+
+const (
+	FieldName            Field = 0x02
+	FieldAlbum           Field = 0x03
+	FieldArtist          Field = 0x04
+	FieldBitRate         Field = 0x05
+	FieldSampleRate      Field = 0x06
+	FieldYear            Field = 0x07
+	FieldGenre           Field = 0x08
+	FieldKind            Field = 0x09
+	FieldDateModified    Field = 0x0a
+	FieldTrackNumber     Field = 0x0b
+	FieldSize            Field = 0x0c
+	FieldTime            Field = 0x0d
+	FieldComments        Field = 0x0e
+	FieldDateAdded       Field = 0x10
+	FieldComposer        Field = 0x12
+	FieldPlayCount       Field = 0x16
+	FieldLastPlayed      Field = 0x17
+	FieldDiscNumber      Field = 0x18
+	FieldRating          Field = 0x19
+	FieldCompilation     Field = 0x1f
+	FieldBPM             Field = 0x23
+	FieldGrouping        Field = 0x27
+	FieldPlaylist        Field = 0x28
+	FieldSkipCount       Field = 0x44
+	FieldLastSkipped     Field = 0x45
+	FieldAlbumArtist     Field = 0x47
+	FieldSortName        Field = 0x4e
+	FieldSortAlbum       Field = 0x4f
+	FieldSortArtist      Field = 0x50
+	FieldSortAlbumArtist Field = 0x51
+	FieldSortComposer    Field = 0x52
+	FieldAlbumRating     Field = 0x5a
+	FieldLoved           Field = 0x9a
+)
+
+var fieldNames = map[Field]string{
+	FieldName:            "Name",
+	FieldAlbum:           "Album",
+	FieldArtist:          "Artist",
+	FieldBitRate:         "Bit Rate",
+	FieldSampleRate:      "Sample Rate",
+	FieldYear:            "Year",
+	FieldGenre:           "Genre",
+	FieldKind:            "Kind",
+	FieldDateModified:    "Date Modified",
+	FieldTrackNumber:     "Track Number",
+	FieldSize:            "Size",
+	FieldTime:            "Time",
+	FieldComments:        "Comments",
+	FieldDateAdded:       "Date Added",
+	FieldComposer:        "Composer",
+	FieldPlayCount:       "Plays",
+	FieldLastPlayed:      "Last Played",
+	FieldDiscNumber:      "Disc Number",
+	FieldRating:          "Rating",
+	FieldCompilation:     "Compilation",
+	FieldBPM:             "BPM",
+	FieldGrouping:        "Grouping",
+	FieldPlaylist:        "Playlist",
+	FieldSkipCount:       "Skips",
+	FieldLastSkipped:     "Last Skipped",
+	FieldAlbumArtist:     "Album Artist",
+	FieldSortName:        "Sort Name",
+	FieldSortAlbum:       "Sort Album",
+	FieldSortArtist:      "Sort Artist",
+	FieldSortAlbumArtist: "Sort Album Artist",
+	FieldSortComposer:    "Sort Composer",
+	FieldAlbumRating:     "Album Rating",
+	FieldLoved:           "Loved",
+}
+
+func (f Field) String() string {
+	if n, ok := fieldNames[f]; ok {
+		return n
+	}
+	return fmt.Sprintf("Field(0x%x)", uint32(f))
+}
+
+// IsDate returns true if the values of f are times.
+func (f Field) IsDate() bool {
+	switch f {
+	case FieldDateModified, FieldDateAdded, FieldLastPlayed, FieldLastSkipped:
+		return true
+	}
+	return false
+}
+
+// Operator is how a rule matches. Negation is kept in Rule.Not.
+type Operator uint32
+
+const (
+	OpIs         Operator = 0x001
+	OpContains   Operator = 0x002
+	OpStartsWith Operator = 0x004
+	OpEndsWith   Operator = 0x008
+	OpGreater    Operator = 0x010
+	OpLess       Operator = 0x040
+	OpInRange    Operator = 0x100
+	OpInTheLast  Operator = 0x200
+)
+
+var operatorNames = map[Operator]string{
+	OpIs:         "is",
+	OpContains:   "contains",
+	OpStartsWith: "starts with",
+	OpEndsWith:   "ends with",
+	OpGreater:    "is greater than",
+	OpLess:       "is less than",
+	OpInRange:    "is in the range",
+	OpInTheLast:  "is in the last",
+}
+
+var negatedOperatorNames = map[Operator]string{
+	OpIs:         "is not",
+	OpContains:   "does not contain",
+	OpStartsWith: "does not start with",
+	OpEndsWith:   "does not end with",
+	OpGreater:    "is not greater than",
+	OpLess:       "is not less than",
+	OpInRange:    "is not in the range",
+	OpInTheLast:  "is not in the last",
+}
+
+func (o Operator) String() string {
+	if n, ok := operatorNames[o]; ok {
+		return n
+	}
+	return fmt.Sprintf("Operator(0x%x)", uint32(o))
+}
+
+// LimitUnit is what Limit.Count counts.
+type LimitUnit int
+
+const (
+	LimitMinutes LimitUnit = 0x01
+	LimitMB      LimitUnit = 0x02
+	LimitItems   LimitUnit = 0x03
+	LimitHours   LimitUnit = 0x04
+	LimitGB      LimitUnit = 0x05
+)
+
+var limitUnitNames = map[LimitUnit]string{
+	LimitMinutes: "minutes",
+	LimitMB:      "MB",
+	LimitItems:   "items",
+	LimitHours:   "hours",
+	LimitGB:      "GB",
+}
+
+func (u LimitUnit) String() string {
+	if n, ok := limitUnitNames[u]; ok {
+		return n
+	}
+	return fmt.Sprintf("LimitUnit(0x%x)", int(u))
+}
+
+// SelectionMethod picks the songs kept by a Limit.
+type SelectionMethod int
+
+const (
+	SelectRandom     SelectionMethod = 0x02
+	SelectName       SelectionMethod = 0x05
+	SelectAlbum      SelectionMethod = 0x06
+	SelectArtist     SelectionMethod = 0x07
+	SelectGenre      SelectionMethod = 0x09
+	SelectDateAdded  SelectionMethod = 0x15
+	SelectPlayCount  SelectionMethod = 0x19
+	SelectLastPlayed SelectionMethod = 0x1a
+	SelectRating     SelectionMethod = 0x1c
+)
+
+// Limit is "Limit to Count Unit selected by SelectBy".
+type Limit struct {
+	Count    int
+	Unit     LimitUnit
+	SelectBy SelectionMethod
+	// Least reverses SelectBy, e.g., lowest rating or least recently played.
+	Least bool
+}
+
+// RuleGroup is a set of rules where either all or, with Any, one must match.
+type RuleGroup struct {
+	Any   bool
+	Rules []Rule
+}
+
+// Rule is a single criterion, e.g., "Artist contains Rush".
+type Rule struct {
+	Field    Field
+	Operator Operator
+	Not      bool
+
+	// IsText is set for string rules, where the value is Text.
+	IsText bool
+	Text   string
+	// Int is the value of a numeric rule and Int2 is the top of a range.
+	// Ratings are 0-100. Dates are seconds since 1904.
+	Int, Int2 int64
+	// Last is the duration of an "in the last" rule.
+	Last time.Duration
+
+	// Group is set instead of the above for a nested set of rules.
+	Group *RuleGroup
+}
+
+// macEpoch is the zero time of the date values.
+var macEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// MacTime converts seconds since 1904, as used by date rules, to a time.
+func MacTime(secs int64) time.Time { return macEpoch.Add(time.Duration(secs) * time.Second) }
+
+// Time returns Int as a time for date rules.
+func (r Rule) Time() time.Time { return MacTime(r.Int) }
+
+// Time2 returns Int2 as a time for date rules.
+func (r Rule) Time2() time.Time { return MacTime(r.Int2) }
+
+func (r Rule) String() string {
+	if r.Group != nil {
+		if r.Group.Any {
+			return fmt.Sprintf("any of %d rules", len(r.Group.Rules))
+		}
+		return fmt.Sprintf("all of %d rules", len(r.Group.Rules))
+	}
+
+	op := r.Operator.String()
+	if n, ok := negatedOperatorNames[r.Operator]; ok && r.Not {
+		op = n
+	} else if r.Not {
+		op = "not " + op
+	}
+	var value string
+	switch {
+	case r.IsText:
+		value = fmt.Sprintf("%q", r.Text)
+	case r.Operator == OpInTheLast:
+		value = r.Last.String()
+	case r.Field.IsDate() && r.Operator == OpInRange:
+		value = r.Time().Format("2006-01-02") + " to " + r.Time2().Format("2006-01-02")
+	case r.Field.IsDate():
+		value = r.Time().Format("2006-01-02")
+	case r.Operator == OpInRange:
+		value = fmt.Sprintf("%d to %d", r.Int, r.Int2)
+	default:
+		value = fmt.Sprintf("%d", r.Int)
+	}
+	return r.Field.String() + " " + op + " " + value
+}
+
+// SmartPlaylist is the decoded Smart Info and Smart Criteria of a playlist.
+type SmartPlaylist struct {
+	LiveUpdating bool
+	CheckedOnly  bool
+	// Rules is nil if the playlist only has a limit.
+	Rules *RuleGroup
+	// Limit is nil if the playlist isn't limited.
+	Limit *Limit
+}
+
+// IsSmart returns true if p is a smart playlist.
+func (p Playlist) IsSmart() bool { return len(p.SmartInfo) > 0 }
+
+// Smart decodes the smart playlist criteria of p.
+func (p Playlist) Smart() (*SmartPlaylist, error) {
+	return ParseSmartPlaylist(p.SmartInfo, p.SmartCriteria)
+}
+
+// ParseSmartPlaylist decodes the Smart Info and Smart Criteria blobs of a
+// smart playlist.
+func ParseSmartPlaylist(info, criteria []byte) (*SmartPlaylist, error) {
+	if len(info) < smartInfoLen {
+		return nil, fmt.Errorf("smart info too short (%d bytes)", len(info))
+	}
+	sp := &SmartPlaylist{
+		LiveUpdating: info[0] != 0,
+		CheckedOnly:  info[12] != 0,
+	}
+	if info[2] != 0 {
+		sp.Limit = &Limit{
+			Count:    int(binary.BigEndian.Uint32(info[8:12])),
+			Unit:     LimitUnit(info[3]),
+			SelectBy: SelectionMethod(info[7]),
+			Least:    info[13] != 0,
+		}
+	}
+	if info[1] != 0 {
+		g, _, err := parseRuleGroup(criteria, 0)
+		if err != nil {
+			return nil, err
+		}
+		sp.Rules = g
+	}
+	return sp, nil
+}
+
+// parseRuleGroup decodes the group at the start of b, returning the number
+// of bytes used.
+func parseRuleGroup(b []byte, depth int) (*RuleGroup, int, error) {
+	if depth > maxNestedGroups {
+		return nil, 0, errors.New("smart criteria nested too deeply")
+	}
+	if len(b) < groupHeaderLen || string(b[:4]) != groupMagic {
+		return nil, 0, errors.New("smart criteria missing SLst header")
+	}
+
+	g := &RuleGroup{Any: b[15] == 1}
+	count := int(binary.BigEndian.Uint32(b[8:12]))
+	off := groupHeaderLen
+	for i := 0; i < count; i++ {
+		if len(b) < off+ruleHeaderLen {
+			return nil, 0, fmt.Errorf("smart criteria truncated in rule %d", i)
+		}
+		h := b[off : off+ruleHeaderLen]
+		n := int(binary.BigEndian.Uint32(h[52:56]))
+		if len(b) < off+ruleHeaderLen+n {
+			return nil, 0, fmt.Errorf("smart criteria truncated in rule %d", i)
+		}
+		v := b[off+ruleHeaderLen : off+ruleHeaderLen+n]
+
+		r := Rule{
+			Field:    Field(binary.BigEndian.Uint32(h[0:4])),
+			Operator: Operator(binary.BigEndian.Uint32(h[4:8]) & 0xffffff),
+			Not:      h[4]&0x02 != 0,
+			IsText:   h[4]&0x01 != 0,
+		}
+		switch {
+		case len(v) >= 4 && string(v[:4]) == groupMagic:
+			sub, _, err := parseRuleGroup(v, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			r.Group = sub
+		case r.IsText:
+			if n%2 != 0 {
+				return nil, 0, fmt.Errorf("smart criteria rule %d has odd string length %d", i, n)
+			}
+			u := make([]uint16, n/2)
+			for j := range u {
+				u[j] = binary.BigEndian.Uint16(v[2*j:])
+			}
+			r.Text = string(utf16.Decode(u))
+		default:
+			if n < intValueMinLen {
+				return nil, 0, fmt.Errorf("smart criteria rule %d value too short (%d bytes)", i, n)
+			}
+			r.Int = int64(binary.BigEndian.Uint64(v[0:8]))
+			r.Int2 = int64(binary.BigEndian.Uint64(v[24:32]))
+			if r.Operator == OpInTheLast {
+				amount := -int64(binary.BigEndian.Uint64(v[8:16]))
+				unit := int64(binary.BigEndian.Uint64(v[16:24]))
+				r.Last = time.Duration(amount*unit) * time.Second
+			}
+		}
+		g.Rules = append(g.Rules, r)
+		off += ruleHeaderLen + n
+	}
+	return g, off, nil
+}
@@ -0,0 +1,105 @@
+package itunes
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// encodeGroup builds an SLst blob of rules, each already encoded.
+func encodeGroup(any bool, rules ...[]byte) []byte {
+	b := make([]byte, groupHeaderLen)
+	copy(b, groupMagic)
+	binary.BigEndian.PutUint32(b[8:12], uint32(len(rules)))
+	if any {
+		b[15] = 1
+	}
+	for _, r := range rules {
+		b = append(b, r...)
+	}
+	return b
+}
+
+func encodeRule(f Field, sign byte, op Operator, value []byte) []byte {
+	b := make([]byte, ruleHeaderLen)
+	binary.BigEndian.PutUint32(b[0:4], uint32(f))
+	binary.BigEndian.PutUint32(b[4:8], uint32(op))
+	b[4] = sign
+	binary.BigEndian.PutUint32(b[52:56], uint32(len(value)))
+	return append(b, value...)
+}
+
+func encodeText(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return b
+}
+
+func encodeInts(v, last, unit, v2 int64) []byte {
+	b := make([]byte, 68)
+	binary.BigEndian.PutUint64(b[0:8], uint64(v))
+	binary.BigEndian.PutUint64(b[8:16], uint64(-last))
+	binary.BigEndian.PutUint64(b[16:24], uint64(unit))
+	binary.BigEndian.PutUint64(b[24:32], uint64(v2))
+	return b
+}
+
+func TestParseSmartPlaylist(t *testing.T) {
+	info := make([]byte, 24)
+	info[0], info[1], info[2], info[3], info[7], info[13] = 1, 1, 1, byte(LimitItems), byte(SelectRating), 1
+	binary.BigEndian.PutUint32(info[8:12], 25)
+
+	criteria := encodeGroup(false,
+		encodeRule(FieldArtist, 0x01, OpContains, encodeText("Rüsh")),
+		encodeRule(FieldRating, 0x00, OpGreater, encodeInts(60, 0, 0, 0)),
+		encodeRule(FieldLastPlayed, 0x02, OpInTheLast, encodeInts(0x2dae2dae2dae2dae, 2, 604800, 0)),
+		encodeRule(0, 0x00, OpIs, encodeGroup(true,
+			encodeRule(FieldGenre, 0x03, OpIs, encodeText("Pop")),
+			encodeRule(FieldYear, 0x00, OpInRange, encodeInts(1970, 0, 0, 1979)),
+		)),
+	)
+
+	got, err := ParseSmartPlaylist(info, criteria)
+	if err != nil {
+		t.Fatalf("ParseSmartPlaylist() failed: %s", err)
+	}
+	want := &SmartPlaylist{
+		LiveUpdating: true,
+		Limit:        &Limit{Count: 25, Unit: LimitItems, SelectBy: SelectRating, Least: true},
+		Rules: &RuleGroup{Rules: []Rule{
+			{Field: FieldArtist, Operator: OpContains, IsText: true, Text: "Rüsh"},
+			{Field: FieldRating, Operator: OpGreater, Int: 60},
+			{Field: FieldLastPlayed, Operator: OpInTheLast, Not: true, Int: 0x2dae2dae2dae2dae, Last: 14 * 24 * time.Hour},
+			{Operator: OpIs, Group: &RuleGroup{Any: true, Rules: []Rule{
+				{Field: FieldGenre, Operator: OpIs, Not: true, IsText: true, Text: "Pop"},
+				{Field: FieldYear, Operator: OpInRange, Int: 1970, Int2: 1979},
+			}}},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseSmartPlaylist() = %+v, want %+v", got, want)
+	}
+
+	if s := got.Rules.Rules[2].String(); s != "Last Played is not in the last 336h0m0s" {
+		t.Errorf("Rule.String() = %s", s)
+	}
+}
+
+func TestParseSmartPlaylistTruncated(t *testing.T) {
+	info := make([]byte, 24)
+	info[1] = 1
+	criteria := encodeGroup(false, encodeRule(FieldArtist, 0x01, OpContains, encodeText("Rush")))
+
+	for _, n := range []int{0, 4, groupHeaderLen + 10, len(criteria) - 1} {
+		if _, err := ParseSmartPlaylist(info, criteria[:n]); err == nil {
+			t.Errorf("ParseSmartPlaylist() of %d bytes succeeded, want error", n)
+		}
+	}
+	if _, err := ParseSmartPlaylist(info[:4], criteria); err == nil {
+		t.Errorf("ParseSmartPlaylist() with short info succeeded, want error")
+	}
+}
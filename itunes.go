@@ -0,0 +1,157 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/logank/itunes2subsonic/internal/itunes"
+	pb "github.com/schollz/progressbar/v3"
+)
+
+type itunesSong struct {
+	t    itunes.Track
+	path string
+}
+
+func (s itunesSong) Id() string          { return strconv.Itoa(s.t.TrackId) }
+func (s itunesSong) Path() string        { return s.path }
+func (s itunesSong) FiveStarRating() int { return s.t.Rating / 20 }
+
+func (s itunesSong) Title() string           { return s.t.Name }
+func (s itunesSong) Artist() string          { return s.t.Artist }
+func (s itunesSong) AlbumArtist() string     { return s.t.AlbumArtist }
+func (s itunesSong) Album() string           { return s.t.Album }
+func (s itunesSong) TrackNumber() int        { return s.t.TrackNumber }
+func (s itunesSong) DiscNumber() int         { return s.t.DiscNumber }
+func (s itunesSong) Duration() time.Duration { return time.Duration(s.t.TotalTime) * time.Millisecond }
+func (s itunesSong) Size() int64             { return int64(s.t.Size) }
+
+func (s itunesSong) PlayCount() int        { return s.t.PlayCount }
+func (s itunesSong) LastPlayed() time.Time { return s.t.PlayDateUTC }
+
+func (s itunesSong) DateAdded() time.Time    { return s.t.DateAdded }
+func (s itunesSong) DateModified() time.Time { return s.t.DateModified }
+
+func (s itunesSong) Starred() bool { return s.t.Loved }
+
+// mbidPattern matches a MusicBrainz ID, e.g., as written to Comments or
+// Grouping by Picard.
+var mbidPattern = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+
+// MusicBrainzId returns the first MusicBrainz ID found in Comments or
+// Grouping. iTunes has no field for it.
+func (s itunesSong) MusicBrainzId() string {
+	if id := mbidPattern.FindString(s.t.Comments); id != "" {
+		return id
+	}
+	return mbidPattern.FindString(s.t.Grouping)
+}
+
+// itunesAlbum groups the tracks of an album for its album rating.
+type itunesAlbum struct {
+	artist, name string
+	rating       int
+}
+
+func (a *itunesAlbum) Id() string          { return "" }
+func (a *itunesAlbum) AlbumArtist() string { return a.artist }
+func (a *itunesAlbum) Name() string        { return a.name }
+func (a *itunesAlbum) FiveStarRating() int { return a.rating }
+
+// ItunesLibrary is a Source reading an iTunes XML library export.
+type ItunesLibrary struct {
+	*itunes.Library
+	path string
+}
+
+// OpenItunesLibrary reads the iTunes XML library at path.
+func OpenItunesLibrary(path string) (*ItunesLibrary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	library, err := itunes.LoadLibrary(f)
+	if err != nil {
+		return nil, err
+	}
+	return &ItunesLibrary{Library: library, path: path}, nil
+}
+
+func (l *ItunesLibrary) Name() string { return "itunes:" + l.path }
+
+// Songs returns the tracks of the library with their locations as file
+// paths, e.g., "M:/Music/AC+DC/Back.mp3". The locations are unescaped here so
+// CanonicalPath doesn't again, and iTunes writes '+' as is.
+func (l *ItunesLibrary) Songs(bar *pb.ProgressBar) ([]SongInfo, error) {
+	songs := make([]SongInfo, 0, len(l.Tracks))
+	for _, v := range l.Tracks {
+		loc, err := url.PathUnescape(urlHost.ReplaceAllString(v.Location, ""))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected iTunes location '%s': %w", v.Location, err)
+		}
+		if urlDrive.MatchString(loc) {
+			loc = loc[1:]
+		}
+
+		songs = append(songs, itunesSong{t: v, path: loc})
+	}
+	if bar != nil {
+		bar.Add(len(songs))
+	}
+	return songs, nil
+}
+
+// Albums groups the tracks by album artist, or artist if unset, and album.
+// Only explicit album ratings are used; iTunes computes the rest from the
+// track ratings.
+func (l *ItunesLibrary) Albums(bar *pb.ProgressBar) ([]AlbumInfo, error) {
+	var albums []AlbumInfo
+	byKey := make(map[string]*itunesAlbum)
+	for _, v := range l.Tracks {
+		if v.Album == "" {
+			continue
+		}
+		artist := v.AlbumArtist
+		if artist == "" {
+			artist = v.Artist
+		}
+
+		k := AlbumKey(artist, v.Album)
+		a, ok := byKey[k]
+		if !ok {
+			a = &itunesAlbum{artist: artist, name: v.Album}
+			byKey[k] = a
+			albums = append(albums, a)
+		}
+		if !v.AlbumRatingComputed && v.AlbumRating/20 > a.rating {
+			a.rating = v.AlbumRating / 20
+		}
+	}
+	if bar != nil {
+		bar.Add(len(albums))
+	}
+	return albums, nil
+}
+
+// Playlists returns the user's playlists. The Library, system playlists
+// (e.g., Music or Podcasts) and folders are left out.
+func (l *ItunesLibrary) Playlists() ([]*PlaylistInfo, error) {
+	var r []*PlaylistInfo
+	for _, v := range l.Library.Playlists {
+		if v.Master || v.DistinguishedKind != 0 || v.Folder {
+			continue
+		}
+		p := &PlaylistInfo{Id: v.PlaylistPersistentId, Name: v.Name}
+		for _, item := range v.PlaylistItems {
+			p.SongIds = append(p.SongIds, strconv.Itoa(item.TrackId))
+		}
+		r = append(r, p)
+	}
+	return r, nil
+}
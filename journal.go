@@ -0,0 +1,386 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// The fields of a song recorded in the journal.
+const (
+	// JournalRating is the song rating, 0-5.
+	JournalRating = "rating"
+	// JournalStarred is 1 if the song is starred, otherwise 0.
+	JournalStarred = "starred"
+	// JournalAlbumRating is the album rating, 0-5, of the album with SongId.
+	JournalAlbumRating = "album_rating"
+)
+
+// JournalEntry is a value of a song changed by a run, recorded so the run can
+// be undone.
+type JournalEntry struct {
+	Run int64
+	// Library is the name of the library changed. Src is set if it was the src
+	// of the run, as by --two_way.
+	Library string
+	Src     bool
+	SongId  string
+	// Path is that of the song, or the AlbumKey of an album.
+	Path  string
+	Field string
+	Old   int
+	New   int
+	Time  time.Time
+}
+
+// record adds e to the journal.
+func (s *StateDb) record(e JournalEntry) error {
+	_, err := s.db.Exec("INSERT INTO journal (run, library, src, song_id, path, field, old, new, time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		e.Run, e.Library, e.Src, e.SongId, e.Path, e.Field, e.Old, e.New, e.Time.Unix())
+	return err
+}
+
+// Journal returns the values changed by run, in the order they were changed.
+func (s *StateDb) Journal(run int64) ([]JournalEntry, error) {
+	rows, err := s.db.Query("SELECT run, library, src, song_id, path, field, old, new, time FROM journal WHERE run = ? ORDER BY id", run)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []JournalEntry
+	for rows.Next() {
+		var e JournalEntry
+		var t int64
+		if err := rows.Scan(&e.Run, &e.Library, &e.Src, &e.SongId, &e.Path, &e.Field, &e.Old, &e.New, &t); err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(t, 0)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// journalSetter records each rating and star it sets in the journal of run,
+// taking the old values from songs and albums, the library as last fetched.
+type journalSetter struct {
+	db         *StateDb
+	run        int64
+	library    string
+	src        bool
+	rater      RatingSetter
+	starrer    Starrer
+	albumRater AlbumRatingSetter
+	songs      map[string]SongInfo
+	albums     map[string]AlbumInfo
+}
+
+func (s *StateDb) newJournalSetter(run int64, library string, src bool, songs []SongInfo) *journalSetter {
+	j := &journalSetter{db: s, run: run, library: library, src: src, songs: make(map[string]SongInfo)}
+	for _, v := range songs {
+		j.songs[v.Id()] = v
+	}
+	return j
+}
+
+// JournalRatings returns a RatingSetter that sets ratings with rater and
+// records them in the journal of run. library names the library rater
+// changes, src is set if it's the src of the run, and songs are its songs as
+// fetched before the run.
+func (s *StateDb) JournalRatings(run int64, library string, src bool, rater RatingSetter, songs []SongInfo) RatingSetter {
+	j := s.newJournalSetter(run, library, src, songs)
+	j.rater = rater
+	return j
+}
+
+// JournalStars is JournalRatings for the stars set with starrer.
+func (s *StateDb) JournalStars(run int64, library string, src bool, starrer Starrer, songs []SongInfo) Starrer {
+	j := s.newJournalSetter(run, library, src, songs)
+	j.starrer = starrer
+	return j
+}
+
+// JournalAlbumRatings is JournalRatings for the album ratings set with rater,
+// albums being those of the library as fetched before the run.
+func (s *StateDb) JournalAlbumRatings(run int64, library string, src bool, rater AlbumRatingSetter, albums []AlbumInfo) AlbumRatingSetter {
+	j := s.newJournalSetter(run, library, src, nil)
+	j.albumRater = rater
+	j.albums = make(map[string]AlbumInfo)
+	for _, v := range albums {
+		j.albums[v.Id()] = v
+	}
+	return j
+}
+
+func (j *journalSetter) SetRating(id string, rating int) error {
+	if err := j.rater.SetRating(id, rating); err != nil {
+		return err
+	}
+	old, path := 0, ""
+	if s, ok := j.songs[id]; ok {
+		old, path = s.FiveStarRating(), s.Path()
+	}
+	return j.add(id, path, JournalRating, old, rating)
+}
+
+func (j *journalSetter) SetStarred(id string, starred bool) error {
+	if err := j.starrer.SetStarred(id, starred); err != nil {
+		return err
+	}
+	old, path := false, ""
+	if s, ok := j.songs[id]; ok {
+		old, path = isStarred(s), s.Path()
+	}
+	return j.add(id, path, JournalStarred, boolInt(old), boolInt(starred))
+}
+
+func (j *journalSetter) SetAlbumRating(id string, rating int) error {
+	if err := j.albumRater.SetAlbumRating(id, rating); err != nil {
+		return err
+	}
+	old, key := 0, ""
+	if a, ok := j.albums[id]; ok {
+		old, key = a.FiveStarRating(), AlbumKey(a.AlbumArtist(), a.Name())
+	}
+	return j.add(id, key, JournalAlbumRating, old, rating)
+}
+
+func (j *journalSetter) add(id, path, field string, old, set int) error {
+	err := j.db.record(JournalEntry{Run: j.run, Library: j.library, Src: j.src, SongId: id, Path: path, Field: field, Old: old, New: set, Time: time.Now()})
+	if err != nil {
+		return fmt.Errorf("set but failed to journal: %w", err)
+	}
+	return nil
+}
+
+// isStarred returns whether s is starred, false if its library doesn't know.
+func isStarred(s SongInfo) bool {
+	st, _ := starred(s)
+	return st
+}
+
+// journalValue returns the current value of field for s.
+func journalValue(s SongInfo, field string) int {
+	if field == JournalStarred {
+		return boolInt(isStarred(s))
+	}
+	return s.FiveStarRating()
+}
+
+// UndoChange is a journal entry to undo. Conflict is set if the value has
+// changed since the run, or the song is gone, so it's left alone.
+type UndoChange struct {
+	JournalEntry
+	// Current is the value now, or -1 if the song is gone.
+	Current  int
+	Conflict bool
+}
+
+// UndoOpener opens a library named in the journal for writing. src is set if
+// it was the src of the run.
+type UndoOpener func(library string, src bool) (Destination, error)
+
+// UndoRun restores the values changed by run in the journal of db, reporting
+// to w. Values changed again since are left alone. Unless dryRun is set, the
+// undo is recorded as a run of its own, so it can be undone in turn.
+func UndoRun(w io.Writer, db *StateDb, run int64, open UndoOpener, dryRun bool, skipCount int) error {
+	entries, err := db.Journal(run)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("run %d changed nothing that can be undone", run)
+	}
+
+	type target struct {
+		library string
+		src     bool
+	}
+	var order []target
+	byTarget := make(map[target][]JournalEntry)
+	for _, e := range entries {
+		t := target{e.Library, e.Src}
+		if _, ok := byTarget[t]; !ok {
+			order = append(order, t)
+		}
+		byTarget[t] = append(byTarget[t], e)
+	}
+
+	var names []string
+	libraries := make(map[target]Destination)
+	changes := make(map[target][]*UndoChange)
+	songs := make(map[target][]SongInfo)
+	albums := make(map[target][]AlbumInfo)
+	for _, t := range order {
+		lib, err := open(t.library, t.src)
+		if err != nil {
+			return err
+		}
+		bar := PbWithOptions(pb.Default(-1, "fetching library data"))
+		list, err := lib.Songs(bar)
+		bar.Finish()
+		if err != nil {
+			return fmt.Errorf("failed while fetching library info: %w", err)
+		}
+		libraries[t], songs[t] = lib, list
+		// Albums that can't be fetched are as good as gone, so left alone.
+		if a, ok := lib.(AlbumLibrary); ok && hasField(byTarget[t], JournalAlbumRating) {
+			bar := PbWithOptions(pb.Default(-1, "fetching album data"))
+			albums[t], err = a.Albums(bar)
+			bar.Finish()
+			if err != nil {
+				return fmt.Errorf("failed while fetching album info: %w", err)
+			}
+		}
+		names = append(names, lib.Name())
+		changes[t] = PlanUndo(byTarget[t], list, albums[t])
+	}
+
+	var all []*UndoChange
+	for _, t := range order {
+		all = append(all, changes[t]...)
+	}
+	PrintUndo(w, run, all)
+	if dryRun {
+		fmt.Fprintf(w, "Set --dry_run=false to modify %s\n", strings.Join(names, ", "))
+		return nil
+	}
+
+	undo, err := db.beginRun(fmt.Sprintf("undo:%d", run), strings.Join(names, ","))
+	if err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	skip := &SkipCounter{Limit: skipCount}
+	restored := 0
+	for _, t := range order {
+		lib := libraries[t]
+		rater := db.JournalRatings(undo, t.library, t.src, lib, songs[t])
+		var starrer Starrer
+		if s, ok := lib.(Starrer); ok {
+			starrer = db.JournalStars(undo, t.library, t.src, s, songs[t])
+		}
+		var albumRater AlbumRatingSetter
+		if a, ok := lib.(AlbumRatingSetter); ok {
+			albumRater = db.JournalAlbumRatings(undo, t.library, t.src, a, albums[t])
+		}
+		bar := PbWithOptions(pb.Default(int64(len(changes[t])), "undo"))
+		for _, c := range changes[t] {
+			bar.Add(1)
+			if c.Conflict {
+				continue
+			}
+			var err error
+			switch {
+			case c.Field == JournalStarred && starrer == nil:
+				err = fmt.Errorf("%s does not support stars", lib.Name())
+			case c.Field == JournalStarred:
+				err = starrer.SetStarred(c.SongId, c.Old != 0)
+			case c.Field == JournalAlbumRating && albumRater == nil:
+				err = fmt.Errorf("%s does not support album ratings", lib.Name())
+			case c.Field == JournalAlbumRating:
+				err = albumRater.SetAlbumRating(c.SongId, c.Old)
+			default:
+				err = rater.SetRating(c.SongId, c.Old)
+			}
+			if err != nil {
+				if err := skip.Skip(c.Path, c.Field, err); err != nil {
+					bar.Finish()
+					return err
+				}
+				continue
+			}
+			restored++
+		}
+		bar.Finish()
+	}
+	if err := db.FinishRun(undo, restored, skip.Count()); err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+	fmt.Fprintf(w, "Undo recorded as run %d\n", undo)
+	return nil
+}
+
+// hasField returns whether any of entries changed field.
+func hasField(entries []JournalEntry, field string) bool {
+	for _, e := range entries {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanUndo returns the changes that undo entries, newest first, given the
+// songs and albums of their library now.
+func PlanUndo(entries []JournalEntry, songs []SongInfo, albums []AlbumInfo) []*UndoChange {
+	byId := make(map[string]SongInfo)
+	for _, s := range songs {
+		byId[s.Id()] = s
+	}
+	albumById := make(map[string]AlbumInfo)
+	for _, a := range albums {
+		albumById[a.Id()] = a
+	}
+	// current returns the value of the field of e now, if the song or album
+	// is still there.
+	current := func(e JournalEntry) (int, bool) {
+		if e.Field == JournalAlbumRating {
+			a, ok := albumById[e.SongId]
+			if !ok {
+				return 0, false
+			}
+			return a.FiveStarRating(), true
+		}
+		s, ok := byId[e.SongId]
+		if !ok {
+			return 0, false
+		}
+		return journalValue(s, e.Field), true
+	}
+	// A value set more than once in the run is undone one step at a time.
+	restored := make(map[[2]string]int)
+	var changes []*UndoChange
+	for i := len(entries) - 1; i >= 0; i-- {
+		c := &UndoChange{JournalEntry: entries[i], Current: -1, Conflict: true}
+		if now, ok := current(c.JournalEntry); ok {
+			k := [2]string{c.SongId, c.Field}
+			v, ok := restored[k]
+			if !ok {
+				v = now
+			}
+			c.Current = v
+			c.Conflict = v != c.New
+			if !c.Conflict {
+				restored[k] = c.Old
+			}
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// PrintUndo writes the "Undo" and "Undo Conflicts" sections of the report.
+func PrintUndo(w io.Writer, run int64, changes []*UndoChange) {
+	fmt.Fprintf(w, "== Undo Run %d ==\n", run)
+	for _, c := range changes {
+		if !c.Conflict {
+			fmt.Fprintf(w, "%s\n\t%s now(%d)\trestore(%d)\n", c.Path, c.Field, c.Current, c.Old)
+		}
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "== Undo Conflicts ==")
+	for _, c := range changes {
+		if !c.Conflict {
+			continue
+		}
+		now := "missing"
+		if c.Current >= 0 {
+			now = fmt.Sprint(c.Current)
+		}
+		fmt.Fprintf(w, "%s\n\t%s now(%s)\tset(%d)\twas(%d)\tleft alone\n", c.Path, c.Field, now, c.New, c.Old)
+	}
+	fmt.Fprintln(w, "")
+}
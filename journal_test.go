@@ -0,0 +1,139 @@
+package itunes2subsonic
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUndoRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := &testLibrary{
+		name:    "subsonic:http://example.com",
+		ratings: map[string]int{"a": 0, "b": 2, "c": 3},
+		stars:   map[string]bool{},
+		albums:  map[string]int{"2112": 0},
+	}
+	db, err := OpenStateDb(filepath.Join(dir, "state.db"), "itunes:lib.xml", lib.name)
+	if err != nil {
+		t.Fatalf("OpenStateDb() failed: %s", err)
+	}
+	defer db.Close()
+
+	run, err := db.BeginRun()
+	if err != nil {
+		t.Fatalf("BeginRun() failed: %s", err)
+	}
+	songs, _ := lib.Songs(nil)
+	rater := db.JournalRatings(run, lib.name, false, lib, songs)
+	starrer := db.JournalStars(run, lib.name, false, lib, songs)
+	rater.SetRating("a", 5)
+	rater.SetRating("b", 4)
+	starrer.SetStarred("c", true)
+	albums, _ := lib.Albums(nil)
+	db.JournalAlbumRatings(run, lib.name, false, lib, albums).SetAlbumRating("2112", 4)
+
+	entries, err := db.Journal(run)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("Journal() = %+v, %v, want 4 entries", entries, err)
+	}
+	if e := entries[1]; e.SongId != "b" || e.Path != "/b/b.mp3" || e.Field != JournalRating || e.Old != 2 || e.New != 4 {
+		t.Errorf("Journal()[1] = %+v", e)
+	}
+	if e := entries[3]; e.SongId != "2112" || e.Path != "rush - 2112" || e.Field != JournalAlbumRating || e.Old != 0 || e.New != 4 {
+		t.Errorf("Journal()[3] = %+v", e)
+	}
+
+	// b was rated again since, so it's left alone.
+	lib.ratings["b"] = 1
+	open := func(library string, src bool) (Destination, error) {
+		if library != lib.name || src {
+			return nil, errors.New("unknown library")
+		}
+		return lib, nil
+	}
+
+	var b bytes.Buffer
+	if err := UndoRun(&b, db, run, open, true, 0); err != nil {
+		t.Fatalf("UndoRun() dry run failed: %s", err)
+	}
+	if lib.ratings["a"] != 5 || !lib.stars["c"] {
+		t.Errorf("UndoRun() dry run changed the library: %v %v", lib.ratings, lib.stars)
+	}
+	if s := b.String(); !strings.Contains(s, "/b/b.mp3\n\trating now(1)\tset(4)\twas(2)\tleft alone\n") {
+		t.Errorf("UndoRun() report = %s", s)
+	}
+
+	if err := UndoRun(ioutil.Discard, db, run, open, false, 0); err != nil {
+		t.Fatalf("UndoRun() failed: %s", err)
+	}
+	if lib.ratings["a"] != 0 || lib.ratings["b"] != 1 || lib.stars["c"] || lib.albums["2112"] != 0 {
+		t.Errorf("UndoRun() left %v %v %v", lib.ratings, lib.stars, lib.albums)
+	}
+
+	// The undo is a run of its own, journaled in turn.
+	runs, err := db.Runs()
+	if err != nil || len(runs) != 2 || runs[1].Src != "undo:1" || runs[1].Pushed != 3 {
+		t.Fatalf("Runs() = %+v, %v", runs, err)
+	}
+	if entries, err := db.Journal(runs[1].Id); err != nil || len(entries) != 3 {
+		t.Errorf("Journal() of undo = %+v, %v, want 3 entries", entries, err)
+	}
+
+	if err := UndoRun(ioutil.Discard, db, 42, open, true, 0); err == nil {
+		t.Errorf("UndoRun() of unknown run succeeded, want error")
+	}
+}
+
+func TestPlanUndo(t *testing.T) {
+	// The rating was set twice in the run, then the song was deleted.
+	entries := []JournalEntry{
+		{SongId: "a", Field: JournalRating, Old: 1, New: 2},
+		{SongId: "a", Field: JournalRating, Old: 2, New: 3},
+		{SongId: "gone", Field: JournalRating, Old: 0, New: 5},
+	}
+	changes := PlanUndo(entries, []SongInfo{testSong{"a", "/b/a.mp3", 3}}, nil)
+	if len(changes) != 3 {
+		t.Fatalf("PlanUndo() len = %d, want 3", len(changes))
+	}
+	if c := changes[0]; !c.Conflict || c.Current != -1 {
+		t.Errorf("PlanUndo()[0] = %+v, want missing song conflict", c)
+	}
+	if c := changes[1]; c.Conflict || c.Current != 3 || c.Old != 2 {
+		t.Errorf("PlanUndo()[1] = %+v, want 3 restored to 2", c)
+	}
+	if c := changes[2]; c.Conflict || c.Current != 2 || c.Old != 1 {
+		t.Errorf("PlanUndo()[2] = %+v, want 2 restored to 1", c)
+	}
+}
+
+func TestPlanUndoAlbums(t *testing.T) {
+	// An album and a song may share an ID.
+	entries := []JournalEntry{
+		{SongId: "a", Field: JournalAlbumRating, Old: 0, New: 4},
+		{SongId: "a", Field: JournalRating, Old: 0, New: 5},
+	}
+	changes := PlanUndo(entries, []SongInfo{testSong{"a", "/b/a.mp3", 2}}, []AlbumInfo{testAlbum{"a", 4}})
+	if len(changes) != 2 {
+		t.Fatalf("PlanUndo() len = %d, want 2", len(changes))
+	}
+	if c := changes[0]; !c.Conflict || c.Current != 2 {
+		t.Errorf("PlanUndo()[0] = %+v, want song conflict", c)
+	}
+	if c := changes[1]; c.Conflict || c.Current != 4 || c.Old != 0 {
+		t.Errorf("PlanUndo()[1] = %+v, want album 4 restored to 0", c)
+	}
+
+	if c := PlanUndo(entries[:1], nil, nil)[0]; !c.Conflict || c.Current != -1 {
+		t.Errorf("PlanUndo() of missing album = %+v, want conflict", c)
+	}
+}
@@ -0,0 +1,168 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// The strategies that can pair songs, as reported in SongPair.Strategy.
+const (
+	MatchPath        = "path"
+	MatchId          = "id"
+	MatchMusicBrainz = "musicbrainz"
+	MatchMetadata    = "metadata"
+)
+
+// matchWeights are how much each field adds to the confidence of a metadata
+// match. They sum to 1.
+var matchWeights = struct {
+	title, artist, album, track, disc, duration, size float64
+}{0.25, 0.2, 0.15, 0.1, 0.05, 0.15, 0.1}
+
+// durationSlop is how far apart durations may be and still match. Subsonic
+// reports whole seconds while iTunes has milliseconds.
+const durationSlop = 2 * time.Second
+
+// minSimilarity is the least Similarity of two texts that counts towards a
+// match, so unrelated titles don't add up to a match on the other fields.
+const minSimilarity = 0.75
+
+// textScore returns the Similarity of a and b after normalizing, or 0 if it's
+// less than minSimilarity.
+func textScore(n *TextNormalizer, a, b string) float64 {
+	s := Similarity(n.Normalize(a), n.Normalize(b))
+	if s < minSimilarity {
+		return 0
+	}
+	return s
+}
+
+// MatchScore returns the confidence, from 0 to 1, that src and dst are the
+// same song. Text is compared with n.
+func MatchScore(src, dst SongMetadata, n *TextNormalizer) float64 {
+	w := matchWeights
+	score := w.title*textScore(n, src.Title(), dst.Title()) +
+		w.artist*textScore(n, src.Artist(), dst.Artist()) +
+		w.album*textScore(n, src.Album(), dst.Album())
+	if t := src.TrackNumber(); t != 0 && t == dst.TrackNumber() {
+		score += w.track
+	}
+	if d := src.DiscNumber(); d != 0 && d == dst.DiscNumber() {
+		score += w.disc
+	}
+	if d := src.Duration() - dst.Duration(); src.Duration() != 0 && d <= durationSlop && d >= -durationSlop {
+		score += w.duration
+	}
+	if sz := src.Size(); sz != 0 && sz == dst.Size() {
+		score += w.size
+	}
+	return score
+}
+
+// MatchByMetadata pairs the songs left unmatched by path whose metadata
+// scores at least threshold, comparing text with n. Only songs with the same
+// normalized title or artist are compared. A src song with more than one
+// equally good candidate is left unmatched. Returns the number of new pairs.
+func (p *Pairing) MatchByMetadata(threshold float64, n *TextNormalizer) int {
+	type candidate struct {
+		src, dst *SongPair
+		score    float64
+	}
+
+	// Index by both so that a song with a misspelled title or artist is
+	// still compared.
+	dstByTitle := make(map[string][]*SongPair)
+	dstByArtist := make(map[string][]*SongPair)
+	for _, v := range p.Pairs {
+		if v.Src != nil || v.Dst == nil {
+			continue
+		}
+		if m, ok := MetadataOf(v.Dst); ok {
+			t := n.Normalize(m.Title())
+			dstByTitle[t] = append(dstByTitle[t], v)
+			a := n.Normalize(m.Artist())
+			dstByArtist[a] = append(dstByArtist[a], v)
+		}
+	}
+
+	var best []candidate
+	for _, v := range p.Pairs {
+		if v.Src == nil || v.Dst != nil {
+			continue
+		}
+		m, ok := MetadataOf(v.Src)
+		if !ok {
+			continue
+		}
+
+		var top candidate
+		tied := false
+		seen := make(map[*SongPair]bool)
+		byTitle, byArtist := dstByTitle[n.Normalize(m.Title())], dstByArtist[n.Normalize(m.Artist())]
+		for _, d := range append(byTitle[:len(byTitle):len(byTitle)], byArtist...) {
+			if seen[d] {
+				continue
+			}
+			seen[d] = true
+			dm, _ := MetadataOf(d.Dst)
+			s := MatchScore(m, dm, n)
+			if s > top.score {
+				top, tied = candidate{v, d, s}, false
+			} else if s == top.score {
+				tied = true
+			}
+		}
+		if top.dst != nil && !tied && top.score >= threshold {
+			best = append(best, top)
+		}
+	}
+
+	// Take the most confident first in case two src songs want the same dst.
+	sort.SliceStable(best, func(i, j int) bool { return best[i].score > best[j].score })
+	taken := make(map[*SongPair]bool)
+	for _, c := range best {
+		if taken[c.dst] {
+			continue
+		}
+		taken[c.dst] = true
+		c.src.Dst = c.dst.Dst
+		c.src.Strategy = MatchMetadata
+		c.src.Confidence = c.score
+	}
+
+	if len(taken) > 0 {
+		var pairs []*SongPair
+		for _, v := range p.Pairs {
+			if !taken[v] {
+				pairs = append(pairs, v)
+			}
+		}
+		p.Pairs = pairs
+	}
+	return len(taken)
+}
+
+// PrintMatchStrategies writes the "Match Strategies" section of the report,
+// listing the pairs not matched by path.
+func PrintMatchStrategies(w io.Writer, p *Pairing) {
+	counts := make(map[string]int)
+	fmt.Fprintln(w, "== Match Strategies ==")
+	for _, v := range p.Matched() {
+		counts[v.Strategy]++
+		// Songs paired by path or ID are certain so only counted.
+		if v.Strategy != MatchPath && v.Strategy != MatchId {
+			fmt.Fprintf(w, "%s\n\t%s src(%s)\tdst(%s)\tconfidence(%.2f)\n", v.Key, v.Strategy, v.Src.Path(), v.Dst.Path(), v.Confidence)
+		}
+	}
+	var names []string
+	for k := range counts {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		fmt.Fprintf(w, "%s matched %d\n", k, counts[k])
+	}
+	fmt.Fprintln(w, "")
+}
@@ -0,0 +1,69 @@
+package itunes2subsonic
+
+import (
+	"testing"
+	"time"
+)
+
+type testMetaSong struct {
+	testSong
+	title, artist, album string
+	track                int
+	duration             time.Duration
+	size                 int64
+}
+
+func (s testMetaSong) Title() string           { return s.title }
+func (s testMetaSong) Artist() string          { return s.artist }
+func (s testMetaSong) AlbumArtist() string     { return "" }
+func (s testMetaSong) Album() string           { return s.album }
+func (s testMetaSong) TrackNumber() int        { return s.track }
+func (s testMetaSong) DiscNumber() int         { return 1 }
+func (s testMetaSong) Duration() time.Duration { return s.duration }
+func (s testMetaSong) Size() int64             { return s.size }
+
+func TestMatchByMetadata(t *testing.T) {
+	src := []SongInfo{
+		testMetaSong{testSong{"1", "/a/Rush/2112/01.mp3", 5}, "2112", "Rush", "2112", 1, 1233500 * time.Millisecond, 100},
+		testMetaSong{testSong{"2", "/a/Rush/2112/02.mp3", 4}, "A Passage to Bangkok", "Rush", "2112", 2, 214 * time.Second, 200},
+		// Same title and artist, but nothing else agrees.
+		testMetaSong{testSong{"3", "/a/Rush/Live/03.mp3", 3}, "Tears", "Rush", "Live", 9, 100 * time.Second, 300},
+		// Two equally good candidates.
+		testMetaSong{testSong{"4", "/a/Various/04.mp3", 3}, "Intro", "Various", "", 0, 60 * time.Second, 0},
+		testSong{"5", "/a/Unknown.mp3", 0},
+		testMetaSong{testSong{"6", "/a/Queen/01.mp3", 5}, "Bohemian Rhapsody - Remastered 2011", "Queen", "A Night at the Opera (Deluxe Edition)", 11, 354 * time.Second, 600},
+	}
+	dst := []SongInfo{
+		testMetaSong{testSong{"a", "/b/rush - 2112 - 01.mp3", 0}, "2112", "rush", "2112", 1, 1234 * time.Second, 101},
+		testMetaSong{testSong{"b", "/b/rush - 2112 - 02.mp3", 0}, "A  Passage To Bangkok", "Rush", "2112", 2, 214 * time.Second, 200},
+		testMetaSong{testSong{"c", "/b/rush - 2112 - 05.mp3", 0}, "Tears", "Rush", "2112", 5, 211 * time.Second, 500},
+		testMetaSong{testSong{"d", "/b/intro1.mp3", 0}, "Intro", "Various", "", 0, 60 * time.Second, 0},
+		testMetaSong{testSong{"e", "/b/intro2.mp3", 0}, "Intro", "Various", "", 0, 60 * time.Second, 0},
+		testMetaSong{testSong{"f", "/b/queen.mp3", 0}, "Bohemian Rhapsody", "Queen", "A Night At The Opera", 11, 355 * time.Second, 0},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+	if got := len(p.Matched()); got != 0 {
+		t.Fatalf("Matched() len before = %d, want 0", got)
+	}
+
+	norm, err := NewTextNormalizer(DefaultSuffixPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.MatchByMetadata(0.8, norm); n != 3 {
+		t.Errorf("MatchByMetadata() = %d, want 3", n)
+	}
+	got := make(map[string]string)
+	for _, v := range p.Matched() {
+		got[v.Src.Id()] = v.Dst.Id()
+		if v.Strategy != MatchMetadata {
+			t.Errorf("pair %s strategy = %s, want %s", v.Key, v.Strategy, MatchMetadata)
+		}
+	}
+	if len(got) != 3 || got["1"] != "a" || got["2"] != "b" || got["6"] != "f" {
+		t.Errorf("Matched() = %v, want 1:a 2:b 6:f", got)
+	}
+	if want := 3 + 3; len(p.Missing()) != want {
+		t.Errorf("Missing() len = %d, want %d", len(p.Missing()), want)
+	}
+}
@@ -0,0 +1,213 @@
+package itunes2subsonic
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NavidromeOptions configures the Navidrome SQL export. Navidrome doesn't
+// expose the added date or play count through the Subsonic API, so these are
+// written as a script to run against navidrome.db instead.
+type NavidromeOptions struct {
+	// SqlFile is where to write the script. Disabled if empty.
+	SqlFile string
+	// PlayData also sets the play count and play date for User.
+	PlayData bool
+	// VerifyDb is a copy of navidrome.db to check for the changes the script
+	// would make. Disabled if empty.
+	VerifyDb string
+	// User is the Navidrome user name owning the play data.
+	User string
+}
+
+// RegisterFlags registers the Navidrome export flags on fs.
+func (o *NavidromeOptions) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.SqlFile, "navidrome_created_file", "", "a file to write SQL statements to update the created time in navidrome.db")
+	fs.BoolVar(&o.PlayData, "navidrome_created_play_data", false, "also write SQL statements to update the play count and play date")
+	fs.StringVar(&o.VerifyDb, "navidrome_verify", "", "path to a copy of navidrome.db to check that the --navidrome_created_file statements were applied")
+}
+
+// Enabled returns whether the script is to be written or verified.
+func (o *NavidromeOptions) Enabled() bool {
+	return o.SqlFile != "" || o.VerifyDb != ""
+}
+
+// Run writes and/or verifies the script for the matched songs of p as
+// configured.
+func (o *NavidromeOptions) Run(w io.Writer, p *Pairing) error {
+	if o.SqlFile != "" {
+		f, err := os.Create(o.SqlFile)
+		if err != nil {
+			return fmt.Errorf("failed to open --navidrome_created_file: %w", err)
+		}
+		defer f.Close()
+
+		if err := WriteNavidromeSql(f, p.Matched(), o.User, o.PlayData); err != nil {
+			return fmt.Errorf("failed to write --navidrome_created_file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write --navidrome_created_file: %w", err)
+		}
+	}
+
+	if o.VerifyDb != "" {
+		db, err := sql.Open(sqliteDriver, "file:"+o.VerifyDb+"?mode=ro")
+		if err != nil {
+			return fmt.Errorf("failed to open --navidrome_verify: %w", err)
+		}
+		defer db.Close()
+
+		count, err := VerifyNavidromeDb(w, db, p.Matched(), o.User, o.PlayData)
+		if err != nil {
+			return fmt.Errorf("failed to verify %s: %w", o.VerifyDb, err)
+		}
+		fmt.Fprintf(w, "== Unapplied Navidrome Update Count %d ==\n", count)
+	}
+
+	return nil
+}
+
+// navidromeUpdate is what the script sets for a single song.
+type navidromeUpdate struct {
+	key       string
+	id        string
+	created   time.Time
+	playCount int
+	played    time.Time
+}
+
+func navidromeUpdates(pairs []*SongPair, playData bool) []navidromeUpdate {
+	var r []navidromeUpdate
+	for _, v := range pairs {
+		u := navidromeUpdate{key: v.Key, id: v.Dst.Id()}
+		if d, ok := v.Src.(SongDates); ok {
+			u.created = d.DateAdded()
+		}
+		if playData {
+			u.playCount = playCount(v.Src)
+			u.played = lastPlayed(v.Src)
+		}
+		if u.created.IsZero() && u.playCount == 0 {
+			continue
+		}
+		r = append(r, u)
+	}
+	return r
+}
+
+// WriteNavidromeSql writes a script setting the created time of each dst song
+// to the src added date. With playData, the play count and play date of user
+// are raised to the src values as well.
+func WriteNavidromeSql(f io.Writer, pairs []*SongPair, user string, playData bool) error {
+	userId := "NULL"
+	if playData {
+		u, err := SqlQuote(user)
+		if err != nil {
+			return err
+		}
+		userId = "(SELECT id FROM user WHERE user_name = " + u + ")"
+	}
+
+	fmt.Fprintln(f, "-- sqlite3 navidrome.db < this_file.sql")
+	fmt.Fprintln(f, "-- Or if using Docker...")
+	fmt.Fprintln(f, "-- docker run --rm -i --user 0 -v navidrome_data:/data keinos/sqlite3:latest sqlite3 /data/navidrome.db < this_file.sql")
+
+	// Wrap everything in a transacation so it's not slow.
+	fmt.Fprintln(f, "BEGIN TRANSACTION;")
+	for _, u := range navidromeUpdates(pairs, playData) {
+		id, err := SqlQuote(u.id)
+		if err != nil {
+			return fmt.Errorf("bad ID for '%s': %w", u.key, err)
+		}
+
+		if !u.created.IsZero() {
+			fmt.Fprintf(f, "UPDATE media_file SET created_at = datetime(%d, 'unixepoch') WHERE id = %s;\n", u.created.Unix(), id)
+		}
+		if u.playCount > 0 {
+			played := "NULL"
+			if !u.played.IsZero() {
+				played = fmt.Sprintf("datetime(%d, 'unixepoch')", u.played.Unix())
+			}
+			// Only ever raise the values so that the script is safe to rerun.
+			fmt.Fprintf(f, "INSERT INTO annotation (ann_id, user_id, item_id, item_type, play_count, play_date) "+
+				"VALUES (lower(hex(randomblob(16))), %s, %s, 'media_file', %d, %s) "+
+				"ON CONFLICT (user_id, item_id, item_type) DO UPDATE SET "+
+				"play_count = max(coalesce(play_count, 0), excluded.play_count), "+
+				"play_date = coalesce(max(play_date, excluded.play_date), play_date, excluded.play_date);\n",
+				userId, id, u.playCount, played)
+		}
+	}
+	_, err := fmt.Fprintln(f, "COMMIT;")
+	return err
+}
+
+// nullUnix parses the result of strftime('%s', ...), which is NULL for a
+// missing time.
+func nullUnix(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	n, err := strconv.ParseInt(s.String, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(n, 0).UTC(), nil
+}
+
+// VerifyNavidromeDb checks that db has the values WriteNavidromeSql would set,
+// writing the songs that differ to w. Returns the number of songs that differ.
+func VerifyNavidromeDb(w io.Writer, db *sql.DB, pairs []*SongPair, user string, playData bool) (int, error) {
+	fmt.Fprintln(w, "== Unapplied Navidrome Updates ==")
+	count := 0
+	for _, u := range navidromeUpdates(pairs, playData) {
+		var problems []string
+
+		if !u.created.IsZero() {
+			var created sql.NullString
+			err := db.QueryRow("SELECT strftime('%s', created_at) FROM media_file WHERE id = ?", u.id).Scan(&created)
+			if err == sql.ErrNoRows {
+				problems = append(problems, "song not found")
+			} else if err != nil {
+				return count, err
+			} else if c, err := nullUnix(created); err != nil {
+				return count, err
+			} else if !c.Equal(u.created.Truncate(time.Second)) {
+				problems = append(problems, fmt.Sprintf("created want(%s) got(%s)", formatTime(u.created), formatTime(c)))
+			}
+		}
+
+		if u.playCount > 0 {
+			var plays sql.NullInt64
+			var played sql.NullString
+			err := db.QueryRow("SELECT a.play_count, strftime('%s', a.play_date) FROM annotation a JOIN user u ON a.user_id = u.id "+
+				"WHERE u.user_name = ? AND a.item_id = ? AND a.item_type = 'media_file'", user, u.id).Scan(&plays, &played)
+			if err != nil && err != sql.ErrNoRows {
+				return count, err
+			}
+			p, perr := nullUnix(played)
+			if perr != nil {
+				return count, perr
+			}
+			if plays.Int64 < int64(u.playCount) {
+				problems = append(problems, fmt.Sprintf("plays want(%d) got(%d)", u.playCount, plays.Int64))
+			}
+			if !u.played.IsZero() && p.Before(u.played) {
+				problems = append(problems, fmt.Sprintf("played want(%s) got(%s)", formatTime(u.played), formatTime(p)))
+			}
+		}
+
+		if len(problems) > 0 {
+			count++
+			fmt.Fprintf(w, "%s\n\t%s\n", u.key, strings.Join(problems, "\t"))
+		}
+	}
+	fmt.Fprintln(w, "")
+
+	return count, nil
+}
@@ -0,0 +1,86 @@
+package itunes2subsonic
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testDatedSong struct {
+	testPlayedSong
+	added time.Time
+}
+
+func (s testDatedSong) DateAdded() time.Time { return s.added }
+
+func TestNavidromeSqlRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "navidrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open(sqliteDriver, filepath.Join(dir, "navidrome.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The subset of the Navidrome schema touched by the script.
+	_, err = db.Exec(`
+CREATE TABLE user (id varchar(255) NOT NULL PRIMARY KEY, user_name varchar(255) NOT NULL UNIQUE);
+CREATE TABLE media_file (id varchar(255) NOT NULL PRIMARY KEY, created_at datetime);
+CREATE TABLE annotation (
+	ann_id varchar(255) NOT NULL PRIMARY KEY,
+	user_id varchar(255) NOT NULL,
+	item_id varchar(255) NOT NULL,
+	item_type varchar(255) NOT NULL,
+	play_count integer,
+	play_date datetime,
+	UNIQUE (user_id, item_id, item_type));
+INSERT INTO user VALUES ('u1', 'o''brien');
+INSERT INTO media_file VALUES ('a', '2023-01-01 00:00:00'), ('it''s', '2023-01-01 00:00:00'), ('c', '2023-01-01 00:00:00');
+INSERT INTO annotation VALUES ('x', 'u1', 'c', 'media_file', 9, '2023-01-01 00:00:00');
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added := time.Date(2010, 1, 2, 3, 4, 5, 0, time.UTC)
+	played := time.Date(2022, 12, 20, 10, 0, 0, 0, time.UTC)
+	pairs := []*SongPair{
+		{Key: "1", Src: testDatedSong{testPlayedSong{testSong{"1", "", 0}, played, 5}, added}, Dst: testSong{"a", "", 0}},
+		{Key: "2", Src: testDatedSong{testPlayedSong{testSong{"2", "", 0}, time.Time{}, 0}, added}, Dst: testSong{"it's", "", 0}},
+		// dst already has more plays than src so only the created time changes.
+		{Key: "3", Src: testDatedSong{testPlayedSong{testSong{"3", "", 0}, played, 2}, added}, Dst: testSong{"c", "", 0}},
+	}
+
+	var out bytes.Buffer
+	if n, err := VerifyNavidromeDb(&out, db, pairs, "o'brien", true); err != nil || n != 3 {
+		t.Fatalf("VerifyNavidromeDb() before = %d, %v, want 3\n%s", n, err, out.String())
+	}
+
+	var script bytes.Buffer
+	if err := WriteNavidromeSql(&script, pairs, "o'brien", true); err != nil {
+		t.Fatalf("WriteNavidromeSql() failed: %s", err)
+	}
+	// Applying twice must be harmless.
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec(script.String()); err != nil {
+			t.Fatalf("applying script failed: %s\n%s", err, script.String())
+		}
+	}
+
+	out.Reset()
+	if n, err := VerifyNavidromeDb(&out, db, pairs, "o'brien", true); err != nil || n != 0 {
+		t.Errorf("VerifyNavidromeDb() after = %d, %v, want 0\n%s", n, err, out.String())
+	}
+
+	var plays int
+	if err := db.QueryRow("SELECT play_count FROM annotation WHERE item_id = 'c'").Scan(&plays); err != nil || plays != 9 {
+		t.Errorf("play_count for c = %d, %v, want 9", plays, err)
+	}
+}
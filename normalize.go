@@ -0,0 +1,129 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultSuffixPatterns match the credits and edition notes that libraries
+// disagree on, e.g., "(feat. Alicia Keys)" or " - Remastered 2011". They're
+// matched against case folded text.
+var DefaultSuffixPatterns = []string{
+	// (feat. X), [with X]
+	`\s*[\(\[](feat\.?|featuring|ft\.?|with)\s[^\)\]]*[\)\]]`,
+	// feat. X
+	`\s+(feat\.?|featuring|ft\.)\s.*$`,
+	// (Remastered 2011), [2015 Remaster]
+	`\s*[\(\[][^\)\]]*\bre-?master(ed)?\b[^\)\]]*[\)\]]`,
+	// - Remastered 2011, - 2015 Remaster
+	`\s+-\s+[^-]*\bre-?master(ed)?\b.*$`,
+	// (Deluxe Edition), [Expanded]
+	`\s*[\(\[][^\)\]]*\b(deluxe|expanded|anniversary)\b[^\)\]]*[\)\]]`,
+}
+
+// TextNormalizer folds metadata text so that variants of the same title
+// compare equal. Results are cached so it isn't safe for concurrent use.
+type TextNormalizer struct {
+	suffixes []*regexp.Regexp
+	cache    map[string]string
+}
+
+// NewTextNormalizer returns a TextNormalizer that also strips the suffixes
+// matching patterns.
+func NewTextNormalizer(patterns []string) (*TextNormalizer, error) {
+	n := &TextNormalizer{cache: make(map[string]string)}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad suffix pattern '%s': %w", p, err)
+		}
+		n.suffixes = append(n.suffixes, re)
+	}
+	return n, nil
+}
+
+// Normalize decomposes s (NFKD), drops the accents, folds the case, strips
+// the suffixes and replaces punctuation with spaces, e.g., "Señorita (feat.
+// X) - Remastered" becomes "senorita".
+func (n *TextNormalizer) Normalize(s string) string {
+	if r, ok := n.cache[s]; ok {
+		return r
+	}
+	r := n.normalize(s)
+	n.cache[s] = r
+	return r
+}
+
+func (n *TextNormalizer) normalize(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), cases.Fold())
+	s, _, err := transform.String(t, s)
+	if err != nil {
+		return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	}
+
+	for _, re := range n.suffixes {
+		s = re.ReplaceAllString(s, "")
+	}
+
+	s = strings.Replace(s, "&", " and ", -1)
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\'' || r == '’':
+			// Contractions, e.g., "don't" and "dont".
+			return -1
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// EditDistance returns the Levenshtein distance between a and b in runes.
+func EditDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Similarity returns how alike a and b are, from 0 to 1, by edit distance.
+// Empty strings are never similar.
+func Similarity(a, b string) float64 {
+	la, lb := len([]rune(a)), len([]rune(b))
+	if la == 0 || lb == 0 {
+		return 0
+	}
+	longest := la
+	if lb > longest {
+		longest = lb
+	}
+	return 1 - float64(EditDistance(a, b))/float64(longest)
+}
@@ -0,0 +1,94 @@
+package itunes2subsonic
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	n, err := NewTextNormalizer(DefaultSuffixPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Variants seen between iTunes, Navidrome and streaming service tags.
+	same := []struct{ a, b string }{
+		{"Bohemian Rhapsody - Remastered 2011", "Bohemian Rhapsody"},
+		{"Bohemian Rhapsody (Remastered 2011)", "Bohemian Rhapsody"},
+		{"Hey Jude - 2015 Remaster", "Hey Jude"},
+		{"Wish You Were Here [2011 - Remaster]", "Wish You Were Here"},
+		{"Paranoid Android (Re-mastered)", "Paranoid Android"},
+		{"Empire State of Mind (feat. Alicia Keys)", "Empire State Of Mind"},
+		{"Crazy in Love ft. JAY-Z", "Crazy In Love"},
+		{"Lose Control [featuring Ciara & Fat Man Scoop]", "Lose Control"},
+		{"Under Pressure (with David Bowie)", "Under Pressure"},
+		{"Rumours (Deluxe Edition)", "Rumours"},
+		{"Señorita", "Senorita"},
+		{"Beyoncé", "BEYONCE"},
+		{"Sigur Rós", "Sigur Ros"},
+		{"Motörhead", "Motorhead"},
+		{"Straße", "STRASSE"},
+		{"Ｆｕｌｌｗｉｄｔｈ", "Fullwidth"},
+		{"Don't Stop Me Now", "Dont Stop Me Now"},
+		{"Don’t Stop Me Now", "Don't Stop Me Now"},
+		{"Rock & Roll", "Rock and Roll"},
+		{"…And Justice for All", "...And Justice For All"},
+		{"Hello, Goodbye", "Hello Goodbye"},
+		{"Mr. Brightside", "Mr Brightside"},
+		{"  Tears   ", "tears"},
+	}
+	for _, tt := range same {
+		if a, b := n.Normalize(tt.a), n.Normalize(tt.b); a != b {
+			t.Errorf("Normalize(%q) = %q, Normalize(%q) = %q, want equal", tt.a, a, tt.b, b)
+		}
+	}
+
+	different := []struct{ a, b string }{
+		{"Tears", "Tears (Live)"},
+		{"Yesterday", "Yesterday Once More"},
+		{"Feat", "Featuring"},
+		{"With or Without You", "Or Without You"},
+		{"Mastered", "Master"},
+	}
+	for _, tt := range different {
+		if a, b := n.Normalize(tt.a), n.Normalize(tt.b); a == b {
+			t.Errorf("Normalize(%q) = Normalize(%q) = %q, want different", tt.a, tt.b, a)
+		}
+	}
+}
+
+func TestNormalizeCustomSuffix(t *testing.T) {
+	n, err := NewTextNormalizer(append(DefaultSuffixPatterns, `\s*\(live\)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Normalize("Tears (Live)"); got != "tears" {
+		t.Errorf("Normalize() = %q, want tears", got)
+	}
+
+	if _, err := NewTextNormalizer([]string{"("}); err == nil {
+		t.Errorf("NewTextNormalizer() with a bad pattern succeeded, want error")
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"colour", "color", 1},
+		{"motörhead", "motorhead", 1},
+	}
+	for _, tt := range tests {
+		if got := EditDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("EditDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	if got := Similarity("colour", "color"); got < 0.83 || got > 0.84 {
+		t.Errorf("Similarity(colour, color) = %f, want 5/6", got)
+	}
+	if got := Similarity("", "color"); got != 0 {
+		t.Errorf("Similarity(\"\", color) = %f, want 0", got)
+	}
+}
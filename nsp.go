@@ -0,0 +1,288 @@
+package itunes2subsonic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/logank/itunes2subsonic/internal/itunes"
+)
+
+// nspFields maps the iTunes smart playlist fields to the Navidrome ones.
+var nspFields = map[itunes.Field]string{
+	itunes.FieldName:            "title",
+	itunes.FieldAlbum:           "album",
+	itunes.FieldArtist:          "artist",
+	itunes.FieldAlbumArtist:     "albumartist",
+	itunes.FieldGenre:           "genre",
+	itunes.FieldComposer:        "composer",
+	itunes.FieldComments:        "comment",
+	itunes.FieldGrouping:        "grouping",
+	itunes.FieldSortName:        "sorttitle",
+	itunes.FieldSortAlbum:       "sortalbum",
+	itunes.FieldSortArtist:      "sortartist",
+	itunes.FieldSortAlbumArtist: "sortalbumartist",
+	itunes.FieldYear:            "year",
+	itunes.FieldTrackNumber:     "tracknumber",
+	itunes.FieldDiscNumber:      "discnumber",
+	itunes.FieldBitRate:         "bitrate",
+	itunes.FieldBPM:             "bpm",
+	itunes.FieldSize:            "size",
+	itunes.FieldTime:            "duration",
+	itunes.FieldPlayCount:       "playcount",
+	itunes.FieldRating:          "rating",
+	itunes.FieldLoved:           "loved",
+	itunes.FieldCompilation:     "compilation",
+	itunes.FieldDateAdded:       "dateadded",
+	itunes.FieldDateModified:    "datemodified",
+	itunes.FieldLastPlayed:      "lastplayed",
+}
+
+// nspSorts maps the limit selection methods to the Navidrome sort fields.
+var nspSorts = map[itunes.SelectionMethod]string{
+	itunes.SelectRandom:     "random",
+	itunes.SelectName:       "title",
+	itunes.SelectAlbum:      "album",
+	itunes.SelectArtist:     "artist",
+	itunes.SelectGenre:      "genre",
+	itunes.SelectDateAdded:  "dateadded",
+	itunes.SelectPlayCount:  "playcount",
+	itunes.SelectLastPlayed: "lastplayed",
+	itunes.SelectRating:     "rating",
+}
+
+// nspValue converts the value of r to the units Navidrome uses.
+func nspValue(r itunes.Rule, v int64) interface{} {
+	switch {
+	case r.Field.IsDate():
+		return itunes.MacTime(v).Format("2006-01-02")
+	case r.Field == itunes.FieldRating:
+		return v / 20
+	case r.Field == itunes.FieldTime:
+		// Milliseconds, as in the library.
+		return v / 1000
+	case r.Field == itunes.FieldLoved || r.Field == itunes.FieldCompilation:
+		return v != 0
+	}
+	return v
+}
+
+// nspRule translates r, which isn't a group, to a Navidrome criterion.
+// Returns nil and the reason if it has no equivalent.
+func nspRule(r itunes.Rule) (map[string]interface{}, string) {
+	field, ok := nspFields[r.Field]
+	if !ok {
+		return nil, "no matching Navidrome field"
+	}
+
+	var op string
+	var value interface{}
+	switch {
+	case r.IsText:
+		value = r.Text
+		switch {
+		case r.Operator == itunes.OpIs && !r.Not:
+			op = "is"
+		case r.Operator == itunes.OpIs:
+			op = "isNot"
+		case r.Operator == itunes.OpContains && !r.Not:
+			op = "contains"
+		case r.Operator == itunes.OpContains:
+			op = "notContains"
+		case r.Operator == itunes.OpStartsWith && !r.Not:
+			op = "startsWith"
+		case r.Operator == itunes.OpEndsWith && !r.Not:
+			op = "endsWith"
+		}
+	case r.Field == itunes.FieldLoved || r.Field == itunes.FieldCompilation:
+		if r.Operator == itunes.OpIs {
+			op = "is"
+			value = (r.Int != 0) != r.Not
+		}
+	case r.Operator == itunes.OpInTheLast:
+		op = "inTheLast"
+		if r.Not {
+			op = "notInTheLast"
+		}
+		days := int64((r.Last + 24*time.Hour - 1) / (24 * time.Hour))
+		if days < 1 {
+			days = 1
+		}
+		value = days
+	case r.Operator == itunes.OpInRange && !r.Not:
+		op = "inTheRange"
+		value = []interface{}{nspValue(r, r.Int), nspValue(r, r.Int2)}
+	case r.Field.IsDate() && r.Not:
+		// A negated date comparison includes the boundary day, which
+		// Navidrome can't express.
+	case r.Field.IsDate() && r.Operator == itunes.OpGreater:
+		op = "after"
+		value = nspValue(r, r.Int)
+	case r.Field.IsDate() && r.Operator == itunes.OpLess:
+		op = "before"
+		value = nspValue(r, r.Int)
+	case r.Field.IsDate():
+		// Navidrome compares dates exactly so "is" would never match.
+	case r.Operator == itunes.OpIs && !r.Not:
+		op = "is"
+		value = nspValue(r, r.Int)
+	case r.Operator == itunes.OpIs:
+		op = "isNot"
+		value = nspValue(r, r.Int)
+	case r.Operator == itunes.OpGreater && !r.Not:
+		op = "gt"
+		value = nspValue(r, r.Int)
+	case r.Operator == itunes.OpLess && !r.Not:
+		op = "lt"
+		value = nspValue(r, r.Int)
+	}
+	if op == "" {
+		return nil, "no matching Navidrome operator"
+	}
+	return map[string]interface{}{op: map[string]interface{}{field: value}}, ""
+}
+
+// nspGroup translates g, appending the rules that can't be translated to
+// unsupported.
+func nspGroup(g *itunes.RuleGroup, unsupported *[]string) map[string]interface{} {
+	rules := []interface{}{}
+	for _, r := range g.Rules {
+		if r.Group != nil {
+			rules = append(rules, nspGroup(r.Group, unsupported))
+			continue
+		}
+		c, why := nspRule(r)
+		if c == nil {
+			*unsupported = append(*unsupported, fmt.Sprintf("%s: %s", r, why))
+			continue
+		}
+		rules = append(rules, c)
+	}
+	if g.Any {
+		return map[string]interface{}{"any": rules}
+	}
+	return map[string]interface{}{"all": rules}
+}
+
+// TranslateSmartPlaylist converts sp to a Navidrome smart playlist named name.
+// Also returns a description of each part of sp that couldn't be translated
+// and was left out.
+func TranslateSmartPlaylist(name string, sp *itunes.SmartPlaylist) (map[string]interface{}, []string) {
+	var unsupported []string
+	nsp := map[string]interface{}{"all": []interface{}{}}
+	if sp.Rules != nil {
+		nsp = nspGroup(sp.Rules, &unsupported)
+	}
+	nsp["name"] = name
+	nsp["comment"] = "Imported from iTunes"
+
+	if sp.CheckedOnly {
+		unsupported = append(unsupported, "match only checked items: Navidrome has no checked songs")
+	}
+	if l := sp.Limit; l != nil {
+		if l.Unit == itunes.LimitItems {
+			nsp["limit"] = l.Count
+		} else {
+			unsupported = append(unsupported, fmt.Sprintf("limit to %d %s: Navidrome only limits by song count", l.Count, l.Unit))
+		}
+		if sort, ok := nspSorts[l.SelectBy]; !ok {
+			unsupported = append(unsupported, fmt.Sprintf("limit selected by method 0x%x: no matching Navidrome sort", int(l.SelectBy)))
+		} else {
+			nsp["sort"] = sort
+			if sort != "random" {
+				// The alphabetical methods are always ascending, the others
+				// are "most" or "highest" unless reversed.
+				ascending := l.SelectBy == itunes.SelectName || l.SelectBy == itunes.SelectAlbum ||
+					l.SelectBy == itunes.SelectArtist || l.SelectBy == itunes.SelectGenre
+				if ascending || l.Least {
+					nsp["order"] = "asc"
+				} else {
+					nsp["order"] = "desc"
+				}
+			}
+		}
+	}
+	return nsp, unsupported
+}
+
+// nspFileName returns a file name for the playlist name.
+func nspFileName(name string) string {
+	r := strings.NewReplacer("/", "_", "\\", "_", ":", "_", "\x00", "_")
+	return r.Replace(name) + ".nsp"
+}
+
+// SmartPlaylistOptions configures WriteSmartPlaylists.
+type SmartPlaylistOptions struct {
+	// Dir is where the .nsp files are written.
+	Dir string
+	// Names are the playlists to translate. All if empty.
+	Names []string
+	// AllowPartial writes playlists even if some criteria couldn't be
+	// translated.
+	AllowPartial bool
+}
+
+// WriteSmartPlaylists translates the smart playlists of l to Navidrome .nsp
+// files, reporting the criteria that couldn't be translated to w.
+func WriteSmartPlaylists(w io.Writer, l *ItunesLibrary, opts SmartPlaylistOptions) error {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return err
+	}
+	want := make(map[string]bool)
+	for _, n := range opts.Names {
+		want[n] = true
+	}
+	found := make(map[string]bool)
+
+	written := 0
+	fmt.Fprintln(w, "== Unsupported Smart Playlist Criteria ==")
+	for _, p := range l.Library.Playlists {
+		if !p.IsSmart() || p.Master || p.DistinguishedKind != 0 || p.Folder {
+			continue
+		}
+		if len(want) > 0 && !want[p.Name] {
+			continue
+		}
+		found[p.Name] = true
+
+		sp, err := p.Smart()
+		if err != nil {
+			fmt.Fprintf(w, "%s\n\tfailed to decode: %s\n", p.Name, err)
+			continue
+		}
+		nsp, unsupported := TranslateSmartPlaylist(p.Name, sp)
+		if len(unsupported) > 0 {
+			skipped := ""
+			if !opts.AllowPartial {
+				skipped = " (skipped, set --allow_partial to write anyway)"
+			}
+			fmt.Fprintf(w, "%s%s\n\t%s\n", p.Name, skipped, strings.Join(unsupported, "\n\t"))
+			if !opts.AllowPartial {
+				continue
+			}
+		}
+
+		b, err := json.MarshalIndent(nsp, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(opts.Dir, nspFileName(p.Name)), append(b, '\n'), 0644); err != nil {
+			return err
+		}
+		written++
+	}
+	fmt.Fprintln(w, "")
+
+	for _, n := range opts.Names {
+		if !found[n] {
+			fmt.Fprintf(w, "Note: smart playlist '%s' not found\n", n)
+		}
+	}
+	fmt.Fprintf(w, "== Wrote %d Smart Playlists To %s ==\n", written, opts.Dir)
+	return nil
+}
@@ -0,0 +1,48 @@
+package itunes2subsonic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/logank/itunes2subsonic/internal/itunes"
+)
+
+func TestTranslateSmartPlaylist(t *testing.T) {
+	sp := &itunes.SmartPlaylist{
+		LiveUpdating: true,
+		Limit:        &itunes.Limit{Count: 25, Unit: itunes.LimitItems, SelectBy: itunes.SelectRating, Least: true},
+		Rules: &itunes.RuleGroup{Rules: []itunes.Rule{
+			{Field: itunes.FieldArtist, Operator: itunes.OpContains, IsText: true, Text: "Rush"},
+			{Field: itunes.FieldRating, Operator: itunes.OpGreater, Int: 60},
+			{Field: itunes.FieldLastPlayed, Operator: itunes.OpInTheLast, Not: true, Last: 2 * 7 * 24 * time.Hour},
+			{Field: itunes.FieldKind, Operator: itunes.OpContains, IsText: true, Text: "MPEG"},
+			{Group: &itunes.RuleGroup{Any: true, Rules: []itunes.Rule{
+				{Field: itunes.FieldLoved, Operator: itunes.OpIs, Int: 1},
+				{Field: itunes.FieldDateAdded, Operator: itunes.OpInRange, Int: 3471379200, Int2: 3502915200},
+				{Field: itunes.FieldName, Operator: itunes.OpStartsWith, Not: true, IsText: true, Text: "The"},
+			}}},
+		}},
+	}
+
+	nsp, unsupported := TranslateSmartPlaylist("Best", sp)
+	got, err := json.Marshal(nsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"all":[{"contains":{"artist":"Rush"}},{"gt":{"rating":3}},{"notInTheLast":{"lastplayed":14}},` +
+		`{"any":[{"is":{"loved":true}},{"inTheRange":{"dateadded":["2014-01-01","2015-01-01"]}}]}],` +
+		`"comment":"Imported from iTunes","limit":25,"name":"Best","order":"asc","sort":"rating"}`
+	if string(got) != want {
+		t.Errorf("TranslateSmartPlaylist() =\n%s\nwant\n%s", got, want)
+	}
+
+	wantUnsupported := []string{
+		`Kind contains "MPEG": no matching Navidrome field`,
+		`Name does not start with "The": no matching Navidrome operator`,
+	}
+	if !reflect.DeepEqual(unsupported, wantUnsupported) {
+		t.Errorf("TranslateSmartPlaylist() unsupported = %q, want %q", unsupported, wantUnsupported)
+	}
+}
@@ -0,0 +1,400 @@
+package itunes2subsonic
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// SongPair is the same song as seen by the src and dst libraries. Src or Dst
+// is nil if the song is missing from that library.
+type SongPair struct {
+	Key string
+	Src SongInfo
+	Dst SongInfo
+	// Strategy is how the pair was matched, e.g., MatchPath, and Confidence
+	// is from 0 to 1. Only set if Paired.
+	Strategy   string
+	Confidence float64
+}
+
+// Paired returns true if the song was found in both libraries.
+func (p *SongPair) Paired() bool {
+	return p.Src != nil && p.Dst != nil
+}
+
+// Pairing is the result of matching the songs of a src library against a dst
+// library.
+type Pairing struct {
+	// Roots are the library prefixes the paths are relative to, after
+	// applying Rewrites.
+	Roots              []RootMapping
+	Rewrites           []RewriteRule
+	SrcCount, DstCount int
+	// RootGuesses are set if the roots were detected rather than given, one
+	// for each of Roots.
+	RootGuesses []PrefixGuess
+	// Collisions are the keys shared by several songs of one library, sorted
+	// by Key.
+	Collisions []*Collision
+	// Pairs is sorted by Key.
+	Pairs []*SongPair
+}
+
+// PathKey returns the key used to pair a song found at path under root. Both
+// are compared as CanonicalPath.
+func PathKey(path, root string) string {
+	return strings.TrimPrefix(CanonicalPath(path), CanonicalPath(root))
+}
+
+// PairingOptions configures NewPairing.
+type PairingOptions struct {
+	// Roots are the library prefixes. Detected with DetectLibraryRoots if
+	// empty.
+	Roots []RootMapping
+	// Rewrites are applied, in order, to the paths before pairing.
+	Rewrites []RewriteRule
+	// Collisions is how to choose between songs in one library with the same
+	// key, e.g., CollisionRating, the default.
+	Collisions string
+	// ById pairs the songs with the same ID first, for src and dst that are
+	// the same library, e.g., restoring a Snapshot of dst.
+	ById bool
+}
+
+// NewPairing pairs src and dst by their path, after the rewrites, relative to
+// the roots. Each song is relative to the longest root prefixing its path, or
+// kept whole if there's none. Songs with the same ID, with opts.ById, and then
+// MusicBrainz ID are paired first, wherever their files are.
+func NewPairing(src, dst []SongInfo, opts PairingOptions) *Pairing {
+	p := &Pairing{
+		Roots:    opts.Roots,
+		Rewrites: opts.Rewrites,
+		SrcCount: len(src),
+		DstCount: len(dst),
+	}
+	if len(p.Roots) == 0 {
+		p.RootGuesses = detectLibraryRoots(p.paths(src, false), p.paths(dst, true))
+		for _, g := range p.RootGuesses {
+			p.Roots = append(p.Roots, g.Mapping())
+		}
+	}
+
+	var idPairs []*SongPair
+	if opts.ById {
+		idPairs, src, dst = p.pairById(src, dst)
+	}
+	mbPairs, src, dst := p.pairByMusicBrainz(src, dst)
+
+	// Group by key first so that songs with the same key are resolved by
+	// opts.Collisions rather than by the order of the library.
+	group := func(songs []SongInfo, dst bool) ([]string, map[string][]SongInfo) {
+		var keys []string
+		byKey := make(map[string][]SongInfo)
+		for _, s := range songs {
+			k := p.key(p.path(s, dst), dst)
+			if _, ok := byKey[k]; !ok {
+				keys = append(keys, k)
+			}
+			byKey[k] = append(byKey[k], s)
+		}
+		return keys, byKey
+	}
+	srcKeys, srcByKey := group(src, false)
+	dstKeys, dstByKey := group(dst, true)
+
+	skipped := make(map[string]bool)
+	resolve := func(k string, songs []SongInfo, dst bool) SongInfo {
+		if len(songs) == 1 {
+			return songs[0]
+		}
+		c := resolveCollision(k, songs, dst, opts.Collisions)
+		p.Collisions = append(p.Collisions, c)
+		if c.Kept == nil {
+			skipped[k] = true
+		}
+		return c.Kept
+	}
+	srcKept, dstKept := make(map[string]SongInfo), make(map[string]SongInfo)
+	for _, k := range srcKeys {
+		srcKept[k] = resolve(k, srcByKey[k], false)
+	}
+	for _, k := range dstKeys {
+		dstKept[k] = resolve(k, dstByKey[k], true)
+	}
+
+	// An ID or MusicBrainz pair keeps the src path as its key unless another
+	// song has it, as the key must be unique.
+	idPairs = append(idPairs, mbPairs...)
+	idKeys := make(map[string]int)
+	for _, v := range idPairs {
+		idKeys[v.Key]++
+	}
+	for _, v := range idPairs {
+		_, srcTaken := srcByKey[v.Key]
+		_, dstTaken := dstByKey[v.Key]
+		if srcTaken || dstTaken || idKeys[v.Key] > 1 {
+			if v.Strategy == MatchId {
+				v.Key += "#id:" + v.Src.Id()
+			} else {
+				v.Key += "#mbid:" + musicBrainzId(v.Src)
+			}
+		}
+		p.Pairs = append(p.Pairs, v)
+	}
+
+	byKey := make(map[string]*SongPair)
+	get := func(k string) *SongPair {
+		t, ok := byKey[k]
+		if !ok {
+			t = &SongPair{Key: k}
+			byKey[k] = t
+			p.Pairs = append(p.Pairs, t)
+		}
+		return t
+	}
+	for _, k := range srcKeys {
+		if !skipped[k] {
+			get(k).Src = srcKept[k]
+		}
+	}
+	for _, k := range dstKeys {
+		if !skipped[k] {
+			get(k).Dst = dstKept[k]
+		}
+	}
+	for _, v := range p.Pairs {
+		if v.Paired() && v.Strategy == "" {
+			v.Strategy, v.Confidence = MatchPath, 1
+		}
+	}
+
+	sort.Slice(p.Pairs, func(i, j int) bool { return p.Pairs[i].Key < p.Pairs[j].Key })
+	sort.SliceStable(p.Collisions, func(i, j int) bool { return p.Collisions[i].Key < p.Collisions[j].Key })
+	return p
+}
+
+// path returns the path of a src song, or a dst song if dst is set, after
+// applying the Rewrites.
+func (p *Pairing) path(s SongInfo, dst bool) string {
+	return RewritePath(p.Rewrites, s.Path(), dst)
+}
+
+// paths returns the path of each of songs.
+func (p *Pairing) paths(songs []SongInfo, dst bool) []string {
+	r := make([]string, len(songs))
+	for i, s := range songs {
+		r[i] = p.path(s, dst)
+	}
+	return r
+}
+
+// key returns the PathKey of a rewritten src path, or a dst path if dst is
+// set.
+func (p *Pairing) key(path string, dst bool) string {
+	i := rootIndex(path, p.Roots, dst)
+	switch {
+	case i < 0:
+		return PathKey(path, "")
+	case dst:
+		return PathKey(path, p.Roots[i].DstRoot)
+	}
+	return PathKey(path, p.Roots[i].SrcRoot)
+}
+
+// pairById returns a pair for each ID found in both src and dst, keyed by the
+// src path, and the songs left to pair.
+func (p *Pairing) pairById(src, dst []SongInfo) ([]*SongPair, []SongInfo, []SongInfo) {
+	dstById := make(map[string]int)
+	for i, s := range dst {
+		dstById[s.Id()] = i
+	}
+	paired := make([]bool, len(dst))
+	var pairs []*SongPair
+	var restSrc, restDst []SongInfo
+	for _, s := range src {
+		i, ok := dstById[s.Id()]
+		if !ok || paired[i] {
+			restSrc = append(restSrc, s)
+			continue
+		}
+		paired[i] = true
+		pairs = append(pairs, &SongPair{
+			Key:        p.key(p.path(s, false), false),
+			Src:        s,
+			Dst:        dst[i],
+			Strategy:   MatchId,
+			Confidence: 1,
+		})
+	}
+	for i, s := range dst {
+		if !paired[i] {
+			restDst = append(restDst, s)
+		}
+	}
+	return pairs, restSrc, restDst
+}
+
+// pairByMusicBrainz returns a pair for each MusicBrainz ID found exactly once
+// in both src and dst, keyed by the src path, and the songs left to pair.
+func (p *Pairing) pairByMusicBrainz(src, dst []SongInfo) ([]*SongPair, []SongInfo, []SongInfo) {
+	count := func(songs []SongInfo) map[string]int {
+		c := make(map[string]int)
+		for _, s := range songs {
+			if id := musicBrainzId(s); id != "" {
+				c[id]++
+			}
+		}
+		return c
+	}
+	srcCount, dstCount := count(src), count(dst)
+
+	dstById := make(map[string]int)
+	for i, s := range dst {
+		if id := musicBrainzId(s); srcCount[id] == 1 && dstCount[id] == 1 {
+			dstById[id] = i
+		}
+	}
+	if len(dstById) == 0 {
+		return nil, src, dst
+	}
+
+	paired := make([]bool, len(dst))
+	var pairs []*SongPair
+	var restSrc, restDst []SongInfo
+	for _, s := range src {
+		i, ok := dstById[musicBrainzId(s)]
+		if !ok {
+			restSrc = append(restSrc, s)
+			continue
+		}
+		paired[i] = true
+		pairs = append(pairs, &SongPair{
+			Key:        p.key(p.path(s, false), false),
+			Src:        s,
+			Dst:        dst[i],
+			Strategy:   MatchMusicBrainz,
+			Confidence: 1,
+		})
+	}
+	for i, s := range dst {
+		if !paired[i] {
+			restDst = append(restDst, s)
+		}
+	}
+	return pairs, restSrc, restDst
+}
+
+// Matched returns the pairs found in both libraries.
+func (p *Pairing) Matched() []*SongPair {
+	var r []*SongPair
+	for _, v := range p.Pairs {
+		if v.Paired() {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// Missing returns the pairs missing from either library.
+func (p *Pairing) Missing() []*SongPair {
+	var r []*SongPair
+	for _, v := range p.Pairs {
+		if !v.Paired() {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// MismatchedRatings returns the matched pairs where dst should take the src
+// rating. Unless copyUnrated is set, an unrated src never clears dst.
+func (p *Pairing) MismatchedRatings(copyUnrated bool) []*SongPair {
+	var r []*SongPair
+	for _, v := range p.Matched() {
+		if v.Src.FiveStarRating() == v.Dst.FiveStarRating() {
+			continue
+		}
+		if v.Src.FiveStarRating() == 0 && !copyUnrated {
+			continue
+		}
+		r = append(r, v)
+	}
+	return r
+}
+
+// starred returns whether s is starred and whether its library knows.
+func starred(s SongInfo) (bool, bool) {
+	if st, ok := s.(SongStars); ok {
+		return st.Starred(), true
+	}
+	return false, false
+}
+
+// MismatchedStars returns the matched pairs where dst should be starred to
+// match src. Unless copyUnloved is set, dst stars are never removed.
+func (p *Pairing) MismatchedStars(copyUnloved bool) []*SongPair {
+	var r []*SongPair
+	for _, v := range p.Matched() {
+		src, srcOk := starred(v.Src)
+		dst, dstOk := starred(v.Dst)
+		if !srcOk || !dstOk || src == dst {
+			continue
+		}
+		if !src && !copyUnloved {
+			continue
+		}
+		r = append(r, v)
+	}
+	return r
+}
+
+// lastPlayed returns when s was last played, or zero if unknown.
+func lastPlayed(s SongInfo) time.Time {
+	if p, ok := s.(SongPlays); ok {
+		return p.LastPlayed().Truncate(time.Second)
+	}
+	return time.Time{}
+}
+
+// StalePlayDates returns the matched pairs where src was played more recently
+// than dst reports.
+func (p *Pairing) StalePlayDates() []*SongPair {
+	var r []*SongPair
+	for _, v := range p.Matched() {
+		src := lastPlayed(v.Src)
+		if !src.IsZero() && src.After(lastPlayed(v.Dst)) {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// playCount returns the number of times s was played, or 0 if unknown.
+func playCount(s SongInfo) int {
+	if p, ok := s.(SongPlays); ok {
+		return p.PlayCount()
+	}
+	return 0
+}
+
+// MissingPlayCounts returns the matched pairs where src was played more often
+// than dst reports.
+func (p *Pairing) MissingPlayCounts() []*SongPair {
+	var r []*SongPair
+	for _, v := range p.Matched() {
+		if playCount(v.Src) > playCount(v.Dst) {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// MissingPercent returns the percentage of all songs that failed to pair.
+func (p *Pairing) MissingPercent() int {
+	total := p.SrcCount + p.DstCount
+	if total == 0 {
+		return 0
+	}
+	return 100 * len(p.Missing()) / total
+}
@@ -0,0 +1,315 @@
+package itunes2subsonic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testSong struct {
+	id     string
+	path   string
+	rating int
+}
+
+func (s testSong) Id() string          { return s.id }
+func (s testSong) Path() string        { return s.path }
+func (s testSong) FiveStarRating() int { return s.rating }
+
+func TestNewPairing(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", `file://localhost/M:/Music/Rush/2112/01-_2112_.mp3`, 5},
+		testSong{"2", `file://localhost/M:/Music/Rush/2112/02-A_Passage_To_Bangkok.mp3`, 0},
+		testSong{"3", `file://localhost/M:/Music/Rush/2112/03-The_Twilight_Zone.mp3`, 3},
+		testSong{"4", `file://localhost/M:/Music/Rush/2112/04-Lessons.mp3`, 4},
+	}
+	dst := []SongInfo{
+		testSong{"a", `/music/Rush/2112/01-_2112_.mp3`, 5},
+		testSong{"b", `/music/Rush/2112/02-A_Passage_To_Bangkok.mp3`, 2},
+		testSong{"c", `/music/Rush/2112/03-The_Twilight_Zone.mp3`, 0},
+		testSong{"d", `/music/Rush/2112/05-Tears.mp3`, 0},
+	}
+
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{`file://localhost/M:/Music/`, `/music/`}}})
+	if got := len(p.Matched()); got != 3 {
+		t.Errorf("Matched() len = %d, want 3", got)
+	}
+
+	missing := p.Missing()
+	if len(missing) != 2 {
+		t.Fatalf("Missing() len = %d, want 2", len(missing))
+	}
+	if k := missing[0].Key; k != "rush/2112/04-lessons.mp3" || missing[0].Dst != nil {
+		t.Errorf("Missing()[0] = %s, want dst missing for rush/2112/04-lessons.mp3", k)
+	}
+	if k := missing[1].Key; k != "rush/2112/05-tears.mp3" || missing[1].Src != nil {
+		t.Errorf("Missing()[1] = %s, want src missing for rush/2112/05-tears.mp3", k)
+	}
+
+	if got := p.MismatchedRatings(false); len(got) != 1 || got[0].Src.Id() != "3" {
+		t.Errorf("MismatchedRatings(false) = %v, want only src 3", got)
+	}
+	if got := p.MismatchedRatings(true); len(got) != 2 {
+		t.Errorf("MismatchedRatings(true) len = %d, want 2", len(got))
+	}
+	if got := p.MissingPercent(); got != 25 {
+		t.Errorf("MissingPercent() = %d, want 25", got)
+	}
+}
+
+type testPlayedSong struct {
+	testSong
+	played time.Time
+	plays  int
+}
+
+func (s testPlayedSong) PlayCount() int        { return s.plays }
+func (s testPlayedSong) LastPlayed() time.Time { return s.played }
+
+func TestStalePlayDates(t *testing.T) {
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	src := []SongInfo{
+		testPlayedSong{testSong{"1", "/a/1.mp3", 0}, newer, 0},
+		testPlayedSong{testSong{"2", "/a/2.mp3", 0}, older, 0},
+		testPlayedSong{testSong{"3", "/a/3.mp3", 0}, newer.Add(500 * time.Millisecond), 0},
+		testPlayedSong{testSong{"4", "/a/4.mp3", 0}, time.Time{}, 0},
+	}
+	dst := []SongInfo{
+		testPlayedSong{testSong{"a", "/b/1.mp3", 0}, older, 0},
+		testPlayedSong{testSong{"b", "/b/2.mp3", 0}, newer, 0},
+		// Servers may store the scrobble with less precision.
+		testPlayedSong{testSong{"c", "/b/3.mp3", 0}, newer, 0},
+		testPlayedSong{testSong{"d", "/b/4.mp3", 0}, time.Time{}, 0},
+	}
+
+	got := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}}).StalePlayDates()
+	if len(got) != 1 || got[0].Src.Id() != "1" {
+		t.Errorf("StalePlayDates() = %v, want only src 1", got)
+	}
+}
+
+func TestMissingPlayCounts(t *testing.T) {
+	src := []SongInfo{
+		testPlayedSong{testSong{"1", "/a/1.mp3", 0}, time.Time{}, 5},
+		testPlayedSong{testSong{"2", "/a/2.mp3", 0}, time.Time{}, 2},
+		testPlayedSong{testSong{"3", "/a/3.mp3", 0}, time.Time{}, 0},
+		testSong{"4", "/a/4.mp3", 0},
+	}
+	dst := []SongInfo{
+		testPlayedSong{testSong{"a", "/b/1.mp3", 0}, time.Time{}, 3},
+		testPlayedSong{testSong{"b", "/b/2.mp3", 0}, time.Time{}, 2},
+		testPlayedSong{testSong{"c", "/b/3.mp3", 0}, time.Time{}, 4},
+		testPlayedSong{testSong{"d", "/b/4.mp3", 0}, time.Time{}, 0},
+	}
+
+	got := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}}).MissingPlayCounts()
+	if len(got) != 1 || got[0].Src.Id() != "1" {
+		t.Errorf("MissingPlayCounts() = %v, want only src 1", got)
+	}
+}
+
+type testStarredSong struct {
+	testSong
+	starred bool
+}
+
+func (s testStarredSong) Starred() bool { return s.starred }
+
+func TestMismatchedStars(t *testing.T) {
+	src := []SongInfo{
+		testStarredSong{testSong{"1", "/a/1.mp3", 0}, true},
+		testStarredSong{testSong{"2", "/a/2.mp3", 0}, false},
+		testStarredSong{testSong{"3", "/a/3.mp3", 0}, true},
+		testSong{"4", "/a/4.mp3", 0},
+	}
+	dst := []SongInfo{
+		testStarredSong{testSong{"a", "/b/1.mp3", 0}, false},
+		testStarredSong{testSong{"b", "/b/2.mp3", 0}, true},
+		testStarredSong{testSong{"c", "/b/3.mp3", 0}, true},
+		testStarredSong{testSong{"d", "/b/4.mp3", 0}, true},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+
+	if got := p.MismatchedStars(false); len(got) != 1 || got[0].Src.Id() != "1" {
+		t.Errorf("MismatchedStars(false) = %v, want only src 1", got)
+	}
+	if got := p.MismatchedStars(true); len(got) != 2 || got[0].Src.Id() != "1" || got[1].Src.Id() != "2" {
+		t.Errorf("MismatchedStars(true) = %v, want src 1 and 2", got)
+	}
+}
+
+type testMbidSong struct {
+	testSong
+	mbid string
+}
+
+func (s testMbidSong) MusicBrainzId() string { return s.mbid }
+
+func TestNewPairingMusicBrainz(t *testing.T) {
+	src := []SongInfo{
+		testMbidSong{testSong{"1", "/a/old/1.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000001"},
+		testMbidSong{testSong{"2", "/a/2.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000002"},
+		testMbidSong{testSong{"3", "/a/3.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000004"},
+		testMbidSong{testSong{"4", "/a/4.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000004"},
+		testMbidSong{testSong{"5", "/a/5.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000005"},
+		testMbidSong{testSong{"6", "/a/6.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000006"},
+	}
+	dst := []SongInfo{
+		testMbidSong{testSong{"a", "/b/new/1.mp3", 0}, "A3A1D0A2-7A5F-4FD5-9D8A-000000000001"},
+		testMbidSong{testSong{"b", "/b/2.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000003"},
+		testMbidSong{testSong{"c", "/b/3.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000004"},
+		// Swapped files.
+		testMbidSong{testSong{"d", "/b/5.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000006"},
+		testMbidSong{testSong{"e", "/b/6.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000005"},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+
+	want := map[string]string{
+		"1": "a " + MatchMusicBrainz,
+		"2": "b " + MatchPath,
+		"3": "c " + MatchPath,
+		"5": "e " + MatchMusicBrainz,
+		"6": "d " + MatchMusicBrainz,
+	}
+	got := make(map[string]string)
+	for _, v := range p.Matched() {
+		got[v.Src.Id()] = v.Dst.Id() + " " + v.Strategy
+	}
+	if len(got) != len(want) {
+		t.Errorf("Matched() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Matched() src %s = %s, want %s", k, got[k], v)
+		}
+	}
+	if m := p.Missing(); len(m) != 1 || m[0].Src.Id() != "4" {
+		t.Errorf("Missing() = %v, want only src 4", m)
+	}
+}
+
+func TestNewPairingMusicBrainzKeys(t *testing.T) {
+	// The song was moved in dst and another put in its place.
+	src := []SongInfo{
+		testMbidSong{testSong{"1", "/a/1.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000001"},
+		testSong{"2", "/a/2.mp3", 0},
+	}
+	dst := []SongInfo{
+		testMbidSong{testSong{"a", "/b/moved/1.mp3", 0}, "a3a1d0a2-7a5f-4fd5-9d8a-000000000001"},
+		testSong{"b", "/b/1.mp3", 0},
+		testSong{"c", "/b/2.mp3", 0},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+
+	got := make(map[string]string)
+	for _, v := range p.Pairs {
+		if _, ok := got[v.Key]; ok {
+			t.Errorf("Pairs has key %s twice", v.Key)
+		}
+		got[v.Key] = songId(v.Src) + "-" + songId(v.Dst)
+	}
+	want := map[string]string{
+		"1.mp3#mbid:a3a1d0a2-7a5f-4fd5-9d8a-000000000001": "1-a",
+		"1.mp3": "-b",
+		"2.mp3": "2-c",
+	}
+	if len(got) != len(want) {
+		t.Errorf("Pairs = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Pairs[%s] = %s, want %s", k, got[k], v)
+		}
+	}
+}
+
+func TestNewPairingById(t *testing.T) {
+	// A snapshot of the library, since which a song was moved and another
+	// put in its place.
+	src := []SongInfo{
+		testSong{"1", "/b/1.mp3", 0},
+		testSong{"2", "/b/2.mp3", 0},
+		testSong{"3", "/b/3.mp3", 0},
+	}
+	dst := []SongInfo{
+		testSong{"1", "/b/moved/1.mp3", 0},
+		testSong{"2", "/b/2.mp3", 0},
+		testSong{"9", "/b/1.mp3", 0},
+		testSong{"4", "/b/3.mp3", 0},
+	}
+	describe := func(p *Pairing) map[string]string {
+		got := make(map[string]string)
+		for _, v := range p.Pairs {
+			if _, ok := got[v.Key]; ok {
+				t.Errorf("Pairs has key %s twice", v.Key)
+			}
+			got[v.Key] = songId(v.Src) + "-" + songId(v.Dst) + " " + v.Strategy
+		}
+		return got
+	}
+
+	got := describe(NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/b/", "/b/"}}, ById: true}))
+	want := map[string]string{
+		"1.mp3#id:1": "1-1 id",
+		"1.mp3":      "-9 ",
+		"2.mp3":      "2-2 id",
+		"3.mp3":      "3-4 path",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewPairing() by ID = %v, want %v", got, want)
+	}
+
+	got = describe(NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/b/", "/b/"}}}))
+	if got["1.mp3"] != "1-9 path" {
+		t.Errorf("NewPairing() = %v, want 1.mp3 paired by path", got)
+	}
+}
+
+func TestNewPairingRoots(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", `file://localhost/M:/Music/Rush/2112/01-_2112_.mp3`, 0},
+		testSong{"2", `file://localhost/M:/Music/Rush/2112/02-A_Passage_To_Bangkok.mp3`, 0},
+		testSong{"3", `file://localhost/D:/Podcasts/Show/01.mp3`, 0},
+		testSong{"4", `file://localhost/D:/Podcasts/Show/02.mp3`, 0},
+		testSong{"5", `file://localhost/E:/Other/01.mp3`, 0},
+	}
+	dst := []SongInfo{
+		testSong{"a", `/music/Rush/2112/01-_2112_.mp3`, 0},
+		testSong{"b", `/podcasts/Show/01.mp3`, 0},
+		testSong{"c", `/podcasts/Show/02.mp3`, 0},
+		testSong{"d", `/podcasts/Show/03.mp3`, 0},
+	}
+	roots := []RootMapping{
+		{`file://localhost/M:/Music/`, `/music/`},
+		{`file://localhost/D:/Podcasts/`, `/podcasts/`},
+	}
+
+	p := NewPairing(src, dst, PairingOptions{Roots: roots})
+	got := make(map[string]string)
+	for _, v := range p.Matched() {
+		got[v.Src.Id()] = v.Dst.Id()
+	}
+	if len(got) != 3 || got["1"] != "a" || got["3"] != "b" || got["4"] != "c" {
+		t.Errorf("Matched() = %v, want 1:a 3:b 4:c", got)
+	}
+
+	want := []RootStats{
+		{RootMapping: roots[0], Src: 2, Dst: 1, Matched: 1},
+		{RootMapping: roots[1], Src: 2, Dst: 3, Matched: 2},
+		{Unmapped: true, Src: 1},
+	}
+	if got := p.RootStats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RootStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseRootMapping(t *testing.T) {
+	got, err := ParseRootMapping("file://localhost/M:/Music/=/music/")
+	if want := (RootMapping{"file://localhost/M:/Music/", "/music/"}); err != nil || got != want {
+		t.Errorf("ParseRootMapping() = %+v, %v, want %+v", got, err, want)
+	}
+	if _, err := ParseRootMapping("/music/"); err == nil {
+		t.Errorf("ParseRootMapping() without '=' succeeded, want error")
+	}
+}
@@ -0,0 +1,259 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// PlaylistInfo is a playlist with the library IDs of its songs in order.
+type PlaylistInfo struct {
+	Id   string
+	Name string
+	// Comment and Public are only known, and copied, with HasDetails. iTunes
+	// playlists have neither.
+	Comment    string
+	Public     bool
+	HasDetails bool
+	SongIds    []string
+}
+
+// PlaylistSource may optionally be implemented by a Source with playlists.
+// System playlists, e.g., the iTunes Library, are left out.
+type PlaylistSource interface {
+	Playlists() ([]*PlaylistInfo, error)
+}
+
+// PlaylistWriter may optionally be implemented by a Destination able to
+// write playlists.
+type PlaylistWriter interface {
+	PlaylistSource
+	// WritePlaylist replaces the playlist with ID id by p, or creates p if id
+	// is empty.
+	WritePlaylist(id string, p *PlaylistInfo) error
+}
+
+// PlaylistUpdate is the change needed on dst for a single src playlist.
+type PlaylistUpdate struct {
+	Src *PlaylistInfo
+	// Dst is the existing dst playlist of the same name, or nil.
+	Dst *PlaylistInfo
+	// Want is Src with the songs that could be found in dst, by dst ID.
+	Want *PlaylistInfo
+	// Unresolved are the keys of the Src songs missing from dst.
+	Unresolved []string
+
+	// Added and Removed are the keys of the songs Want adds to or removes
+	// from Dst. Reordered is set if the songs in both are in a different
+	// order.
+	Added, Removed []string
+	Reordered      bool
+}
+
+// Changed returns true if dst doesn't match Want.
+func (u *PlaylistUpdate) Changed() bool {
+	if u.Dst == nil {
+		return true
+	}
+	if u.Dst.Name != u.Want.Name {
+		return true
+	}
+	if u.Want.HasDetails && (u.Dst.Comment != u.Want.Comment || u.Dst.Public != u.Want.Public) {
+		return true
+	}
+	if len(u.Dst.SongIds) != len(u.Want.SongIds) {
+		return true
+	}
+	for i := range u.Want.SongIds {
+		if u.Dst.SongIds[i] != u.Want.SongIds[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectPlaylists returns the playlists of all with one of names, in the
+// order of all. Also returns the names that weren't found.
+func SelectPlaylists(all []*PlaylistInfo, names []string) ([]*PlaylistInfo, []string) {
+	want := make(map[string]bool)
+	for _, n := range names {
+		want[n] = true
+	}
+	var r []*PlaylistInfo
+	for _, v := range all {
+		if want[v.Name] {
+			r = append(r, v)
+			delete(want, v.Name)
+		}
+	}
+	var notFound []string
+	for _, n := range names {
+		if want[n] {
+			notFound = append(notFound, n)
+		}
+	}
+	return r, notFound
+}
+
+// PlanPlaylists matches each of src to the dst playlist with the same name
+// and maps the songs to dst through p.
+func PlanPlaylists(src, dst []*PlaylistInfo, p *Pairing) []*PlaylistUpdate {
+	dstByName := make(map[string]*PlaylistInfo)
+	for _, v := range dst {
+		if _, ok := dstByName[v.Name]; !ok {
+			dstByName[v.Name] = v
+		}
+	}
+	pairBySrc := make(map[string]*SongPair)
+	keyByDst := make(map[string]string)
+	for _, v := range p.Pairs {
+		if v.Src != nil {
+			pairBySrc[v.Src.Id()] = v
+		}
+		if v.Dst != nil {
+			keyByDst[v.Dst.Id()] = v.Key
+		}
+	}
+	dstKey := func(id string) string {
+		if k, ok := keyByDst[id]; ok {
+			return k
+		}
+		return "id " + id
+	}
+
+	var r []*PlaylistUpdate
+	for _, v := range src {
+		u := &PlaylistUpdate{
+			Src:  v,
+			Dst:  dstByName[v.Name],
+			Want: &PlaylistInfo{Name: v.Name, Comment: v.Comment, Public: v.Public, HasDetails: v.HasDetails},
+		}
+		if u.Dst != nil {
+			u.Want.Id = u.Dst.Id
+		}
+		for _, id := range v.SongIds {
+			pair, ok := pairBySrc[id]
+			if !ok {
+				u.Unresolved = append(u.Unresolved, "id "+id)
+			} else if pair.Dst == nil {
+				u.Unresolved = append(u.Unresolved, pair.Key)
+			} else {
+				u.Want.SongIds = append(u.Want.SongIds, pair.Dst.Id())
+			}
+		}
+		if u.Dst != nil {
+			added, removed, reordered := diffSongIds(u.Want.SongIds, u.Dst.SongIds)
+			for _, id := range added {
+				u.Added = append(u.Added, dstKey(id))
+			}
+			for _, id := range removed {
+				u.Removed = append(u.Removed, dstKey(id))
+			}
+			u.Reordered = reordered
+		}
+		r = append(r, u)
+	}
+	return r
+}
+
+// diffSongIds returns the songs of want missing from have, the songs of have
+// missing from want and whether the songs in both are in a different order.
+// A song may be listed more than once.
+func diffSongIds(want, have []string) ([]string, []string, bool) {
+	haveCount := make(map[string]int)
+	for _, id := range have {
+		haveCount[id]++
+	}
+	wantCount := make(map[string]int)
+	for _, id := range want {
+		wantCount[id]++
+	}
+
+	// common filters ids to the entries also in other, up to the number of
+	// times each is in other.
+	common := func(ids []string, other map[string]int) ([]string, []string) {
+		left := make(map[string]int)
+		for k, v := range other {
+			left[k] = v
+		}
+		var in, out []string
+		for _, id := range ids {
+			if left[id] > 0 {
+				left[id]--
+				in = append(in, id)
+			} else {
+				out = append(out, id)
+			}
+		}
+		return in, out
+	}
+	wantCommon, added := common(want, haveCount)
+	haveCommon, removed := common(have, wantCount)
+
+	reordered := false
+	for i := range wantCommon {
+		if wantCommon[i] != haveCommon[i] {
+			reordered = true
+			break
+		}
+	}
+	return added, removed, reordered
+}
+
+// PrintPlaylistUpdates writes the "Unresolved Playlist Tracks" and "Playlist
+// Changes" sections of the report.
+func PrintPlaylistUpdates(w io.Writer, updates []*PlaylistUpdate) {
+	fmt.Fprintln(w, "== Unresolved Playlist Tracks ==")
+	for _, u := range updates {
+		if len(u.Unresolved) > 0 {
+			fmt.Fprintf(w, "%s\n\t%s\n", u.Src.Name, strings.Join(u.Unresolved, "\n\t"))
+		}
+	}
+	fmt.Fprintln(w, "")
+
+	fmt.Fprintln(w, "== Playlist Changes ==")
+	for _, u := range updates {
+		if !u.Changed() {
+			continue
+		}
+		if u.Dst == nil {
+			fmt.Fprintf(w, "%s\n\tcreate songs(%d)\n", u.Src.Name, len(u.Want.SongIds))
+			continue
+		}
+		fmt.Fprintf(w, "%s\n\tupdate songs src(%d)\tdst(%d)\n", u.Src.Name, len(u.Want.SongIds), len(u.Dst.SongIds))
+		if u.Want.HasDetails && u.Want.Comment != u.Dst.Comment {
+			fmt.Fprintf(w, "\tcomment src(%q)\tdst(%q)\n", u.Want.Comment, u.Dst.Comment)
+		}
+		if u.Want.HasDetails && u.Want.Public != u.Dst.Public {
+			fmt.Fprintf(w, "\tpublic src(%t)\tdst(%t)\n", u.Want.Public, u.Dst.Public)
+		}
+		for _, k := range u.Added {
+			fmt.Fprintf(w, "\tadded %s\n", k)
+		}
+		for _, k := range u.Removed {
+			fmt.Fprintf(w, "\tremoved %s\n", k)
+		}
+		if u.Reordered {
+			fmt.Fprintln(w, "\treordered")
+		}
+	}
+	fmt.Fprintln(w, "")
+}
+
+// CopyPlaylists writes the wanted playlist of each of updates to dst.
+func CopyPlaylists(dst PlaylistWriter, updates []*PlaylistUpdate, skip *SkipCounter) error {
+	bar := PbWithOptions(pb.Default(int64(len(updates)), "write playlist"))
+	defer bar.Finish()
+	for _, u := range updates {
+		err := dst.WritePlaylist(u.Want.Id, u.Want)
+		bar.Add(1)
+		if err != nil {
+			if err := skip.Skip(u.Src.Name, "playlist", err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package itunes2subsonic
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/logank/itunes2subsonic/internal/itunes"
+)
+
+func TestItunesPlaylists(t *testing.T) {
+	l := &ItunesLibrary{Library: &itunes.Library{Playlists: []itunes.Playlist{
+		{Name: "Library", Master: true, PlaylistItems: []itunes.PlaylistItem{{TrackId: 1}, {TrackId: 2}}},
+		{Name: "Music", DistinguishedKind: 4, PlaylistItems: []itunes.PlaylistItem{{TrackId: 1}, {TrackId: 2}}},
+		{Name: "Rock", Folder: true, PlaylistItems: []itunes.PlaylistItem{{TrackId: 1}, {TrackId: 2}}},
+		{Name: "Favs", PlaylistPersistentId: "ABC", PlaylistItems: []itunes.PlaylistItem{{TrackId: 2}, {TrackId: 1}}},
+	}}}
+
+	got, err := l.Playlists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*PlaylistInfo{{Id: "ABC", Name: "Favs", SongIds: []string{"2", "1"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Playlists() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPlanPlaylists(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", "/a/1.mp3", 0},
+		testSong{"2", "/a/2.mp3", 0},
+		testSong{"3", "/a/3.mp3", 0},
+	}
+	dst := []SongInfo{
+		testSong{"a", "/b/1.mp3", 0},
+		testSong{"b", "/b/2.mp3", 0},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+
+	srcLists := []*PlaylistInfo{
+		{Name: "New", SongIds: []string{"3", "2", "1"}},
+		{Name: "Same", SongIds: []string{"1", "2"}},
+		{Name: "Reordered", SongIds: []string{"2", "1", "9"}},
+		{Name: "Shared", Public: true, HasDetails: true, SongIds: []string{"1"}},
+	}
+	// The dst comments and public flags are only compared with the src
+	// playlists that have them, unlike those of iTunes.
+	dstLists := []*PlaylistInfo{
+		{Id: "p1", Name: "Same", Comment: "mine", Public: true, HasDetails: true, SongIds: []string{"a", "b"}},
+		{Id: "p2", Name: "Reordered", SongIds: []string{"a", "b"}},
+		{Id: "p3", Name: "Shared", HasDetails: true, SongIds: []string{"a"}},
+	}
+	updates := PlanPlaylists(srcLists, dstLists, p)
+	if len(updates) != 4 {
+		t.Fatalf("PlanPlaylists() len = %d, want 4", len(updates))
+	}
+
+	if u := updates[0]; !u.Changed() || u.Dst != nil || !reflect.DeepEqual(u.Want.SongIds, []string{"b", "a"}) ||
+		!reflect.DeepEqual(u.Unresolved, []string{"3.mp3"}) {
+		t.Errorf("PlanPlaylists()[0] = %+v, want create of b, a with 3.mp3 unresolved", u.Want)
+	}
+	if u := updates[1]; u.Changed() {
+		t.Errorf("PlanPlaylists()[1].Changed() = true, want false")
+	}
+	if u := updates[2]; !u.Changed() || u.Want.Id != "p2" || !reflect.DeepEqual(u.Want.SongIds, []string{"b", "a"}) ||
+		!reflect.DeepEqual(u.Unresolved, []string{"id 9"}) || !u.Reordered || u.Added != nil || u.Removed != nil {
+		t.Errorf("PlanPlaylists()[2] = %+v, want update of p2 to b, a with id 9 unresolved", u.Want)
+	}
+	if u := updates[3]; !u.Changed() || !u.Want.Public {
+		t.Errorf("PlanPlaylists()[3] = %+v, want update of p3 to public", u.Want)
+	}
+}
+
+func TestDiffSongIds(t *testing.T) {
+	tests := []struct {
+		want, have     []string
+		added, removed []string
+		reordered      bool
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}, nil, nil, false},
+		{[]string{"a", "b", "c"}, []string{"a", "c"}, []string{"b"}, nil, false},
+		{[]string{"c", "a"}, []string{"a", "b", "c"}, nil, []string{"b"}, true},
+		{[]string{"a", "a", "b"}, []string{"a", "b", "b"}, []string{"a"}, []string{"b"}, false},
+		{nil, []string{"a"}, nil, []string{"a"}, false},
+	}
+	for _, tt := range tests {
+		added, removed, reordered := diffSongIds(tt.want, tt.have)
+		if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) || reordered != tt.reordered {
+			t.Errorf("diffSongIds(%q, %q) = %q, %q, %t, want %q, %q, %t",
+				tt.want, tt.have, added, removed, reordered, tt.added, tt.removed, tt.reordered)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package itunes2subsonic
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The formats of the report, as set in SyncOptions.Report.
+const (
+	// ReportText is the report sections, e.g., "== Missing Tracks ==".
+	ReportText = "text"
+	// ReportJson is a JSON array of ReportRecord.
+	ReportJson = "json"
+	// ReportCsv is a ReportRecord per row, under a header of the JSON field
+	// names.
+	ReportCsv = "csv"
+)
+
+// ValidReportFormat returns whether format is one of the Report formats.
+func ValidReportFormat(format string) bool {
+	switch format {
+	case ReportText, ReportJson, ReportCsv:
+		return true
+	}
+	return false
+}
+
+// The actions of a ReportRecord.
+const (
+	// ActionMissing is a song found in only one library.
+	ActionMissing = "missing"
+	// ActionNone is a pair that needed no update.
+	ActionNone = "none"
+	// ActionConflict is a two-way rating conflict left unresolved.
+	ActionConflict = "conflict"
+	// ActionPlanned is a pair that needs updating, in a dry run.
+	ActionPlanned = "planned"
+	// ActionUpdated is a pair that was updated.
+	ActionUpdated = "updated"
+	// ActionFailed is a pair with at least one update that failed.
+	ActionFailed = "failed"
+	// ActionUnfinished is a pair with an update that the run stopped before
+	// finishing, so it may or may not have been made.
+	ActionUnfinished = "unfinished"
+)
+
+// The updates of a ReportRecord.
+const (
+	UpdateRating    = "rating"
+	UpdateSrcRating = "src_rating"
+	UpdateStar      = "star"
+	UpdatePlayCount = "play_count"
+	UpdatePlayTime  = "play_time"
+)
+
+// ReportRecord is what a sync found and did for one song, in a form for
+// scripts to read. The field names are kept stable.
+type ReportRecord struct {
+	Key string `json:"key"`
+	// Match is the strategy that paired the songs, e.g., MatchPath, or
+	// empty if missing.
+	Match      string  `json:"match"`
+	Confidence float64 `json:"confidence"`
+	// The IDs and paths are empty, and the ratings 0, for a missing song.
+	// The ratings are as fetched, before any update.
+	SrcId     string `json:"src_id"`
+	DstId     string `json:"dst_id"`
+	SrcPath   string `json:"src_path"`
+	DstPath   string `json:"dst_path"`
+	SrcRating int    `json:"src_rating"`
+	DstRating int    `json:"dst_rating"`
+	// Action is one of the Action constants, e.g., ActionUpdated, for the
+	// Updates made or planned, e.g., UpdateRating.
+	Action  string   `json:"action"`
+	Updates []string `json:"updates"`
+	// Error is why the updates that failed did, separated by "; ".
+	Error string `json:"error"`
+}
+
+// NewReportRecords returns a record for each of the pairs of p, sorted by
+// key. updates are those planned for each pair, and conflicts the pairs left
+// unresolved. skip reports the updates that failed, and is nil in a dry run.
+// unfinished are the updates, e.g., UpdateRating, that a failed run stopped
+// before finishing.
+func NewReportRecords(p *Pairing, updates map[*SongPair][]string, conflicts map[*SongPair]bool, skip *SkipCounter, unfinished map[string]bool) []ReportRecord {
+	records := make([]ReportRecord, 0, len(p.Pairs))
+	for _, v := range p.Pairs {
+		r := ReportRecord{
+			Key:     v.Key,
+			SrcId:   songId(v.Src),
+			DstId:   songId(v.Dst),
+			Updates: updates[v],
+		}
+		if v.Src != nil {
+			r.SrcPath, r.SrcRating = v.Src.Path(), v.Src.FiveStarRating()
+		}
+		if v.Dst != nil {
+			r.DstPath, r.DstRating = v.Dst.Path(), v.Dst.FiveStarRating()
+		}
+		if r.Updates == nil {
+			r.Updates = []string{}
+		}
+
+		switch {
+		case !v.Paired():
+			r.Action = ActionMissing
+		case conflicts[v]:
+			r.Action = ActionConflict
+		case len(r.Updates) == 0:
+			r.Action = ActionNone
+		case skip == nil:
+			r.Action = ActionPlanned
+		case skip.Skipped(v.Key):
+			r.Action = ActionFailed
+			r.Error = strings.Join(skip.Errors(v.Key), "; ")
+		case anyOf(r.Updates, unfinished):
+			r.Action = ActionUnfinished
+		default:
+			r.Action = ActionUpdated
+		}
+		if v.Paired() {
+			r.Match, r.Confidence = v.Strategy, v.Confidence
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// anyOf returns whether any of updates is in set.
+func anyOf(updates []string, set map[string]bool) bool {
+	for _, u := range updates {
+		if set[u] {
+			return true
+		}
+	}
+	return false
+}
+
+var reportCsvHeader = []string{"key", "match", "confidence", "src_id", "dst_id", "src_path", "dst_path", "src_rating", "dst_rating", "action", "updates", "error"}
+
+// WriteReportRecords writes records to w in format, ReportJson or ReportCsv.
+// In CSV, the updates are separated by ";".
+func WriteReportRecords(w io.Writer, format string, records []ReportRecord) error {
+	switch format {
+	case ReportJson:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case ReportCsv:
+		c := csv.NewWriter(w)
+		if err := c.Write(reportCsvHeader); err != nil {
+			return err
+		}
+		for _, r := range records {
+			row := []string{
+				r.Key, r.Match, strconv.FormatFloat(r.Confidence, 'f', -1, 64),
+				r.SrcId, r.DstId, r.SrcPath, r.DstPath, strconv.Itoa(r.SrcRating), strconv.Itoa(r.DstRating),
+				r.Action, strings.Join(r.Updates, ";"), r.Error,
+			}
+			if err := c.Write(row); err != nil {
+				return err
+			}
+		}
+		c.Flush()
+		return c.Error()
+	}
+	return fmt.Errorf("unknown report format '%s'", format)
+}
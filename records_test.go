@@ -0,0 +1,103 @@
+package itunes2subsonic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReportRecords(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", "/a/same.mp3", 3},
+		testSong{"2", "/a/rated.mp3", 5},
+		testSong{"3", "/a/failed.mp3", 4},
+		testSong{"4", "/a/conflict.mp3", 2},
+		testSong{"5", "/a/src_only.mp3", 1},
+	}
+	dst := []SongInfo{
+		testSong{"a", "/b/same.mp3", 3},
+		testSong{"b", "/b/rated.mp3", 0},
+		testSong{"c", "/b/failed.mp3", 0},
+		testSong{"d", "/b/conflict.mp3", 4},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+	byKey := make(map[string]*SongPair)
+	for _, v := range p.Pairs {
+		byKey[v.Key] = v
+	}
+	updates := map[*SongPair][]string{
+		byKey["rated.mp3"]:  {UpdateRating},
+		byKey["failed.mp3"]: {UpdateRating, UpdateStar},
+	}
+	conflicts := map[*SongPair]bool{byKey["conflict.mp3"]: true}
+
+	actions := func(records []ReportRecord) map[string]string {
+		r := make(map[string]string)
+		for _, v := range records {
+			r[v.Key] = v.Action
+		}
+		return r
+	}
+
+	planned := NewReportRecords(p, updates, conflicts, nil, nil)
+	want := map[string]string{"same.mp3": ActionNone, "rated.mp3": ActionPlanned, "failed.mp3": ActionPlanned, "conflict.mp3": ActionConflict, "src_only.mp3": ActionMissing}
+	if got := actions(planned); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewReportRecords() dry run = %v, want %v", got, want)
+	}
+
+	skip := &SkipCounter{}
+	skip.Skip("failed.mp3", "star", errors.New("not found"))
+	records := NewReportRecords(p, updates, conflicts, skip, nil)
+	want["rated.mp3"], want["failed.mp3"] = ActionUpdated, ActionFailed
+	if got := actions(records); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewReportRecords() = %v, want %v", got, want)
+	}
+	for _, r := range records {
+		switch r.Key {
+		case "failed.mp3":
+			if r.Error != "star: not found" || r.SrcId != "3" || r.DstId != "c" || r.Match != MatchPath || r.SrcRating != 4 {
+				t.Errorf("failed.mp3 record = %+v", r)
+			}
+		case "src_only.mp3":
+			if r.DstId != "" || r.DstPath != "" || r.SrcPath != "/a/src_only.mp3" || r.Match != "" || len(r.Updates) != 0 {
+				t.Errorf("src_only.mp3 record = %+v", r)
+			}
+		}
+	}
+
+	// Stopped before the stars, rated.mp3 was updated but not failed.mp3.
+	stopped := NewReportRecords(p, updates, conflicts, &SkipCounter{}, map[string]bool{UpdateStar: true})
+	want["failed.mp3"] = ActionUnfinished
+	if got := actions(stopped); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewReportRecords() unfinished = %v, want %v", got, want)
+	}
+
+	var b bytes.Buffer
+	if err := WriteReportRecords(&b, ReportJson, records); err != nil {
+		t.Fatalf("WriteReportRecords() json failed: %s", err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil || len(decoded) != len(records) {
+		t.Fatalf("WriteReportRecords() json = %s, %v", b.String(), err)
+	}
+	for _, k := range []string{"key", "match", "confidence", "src_id", "dst_id", "src_path", "dst_path", "src_rating", "dst_rating", "action", "updates", "error"} {
+		if _, ok := decoded[0][k]; !ok {
+			t.Errorf("WriteReportRecords() json is missing %s", k)
+		}
+	}
+
+	b.Reset()
+	if err := WriteReportRecords(&b, ReportCsv, records); err != nil {
+		t.Fatalf("WriteReportRecords() csv failed: %s", err)
+	}
+	if s := b.String(); !strings.HasPrefix(s, "key,match,confidence,") || !strings.Contains(s, "failed.mp3,path,1,3,c,/a/failed.mp3,/b/failed.mp3,4,0,failed,rating;star,star: not found\n") {
+		t.Errorf("WriteReportRecords() csv = %s", s)
+	}
+
+	if err := WriteReportRecords(&b, "xml", records); err == nil {
+		t.Errorf("WriteReportRecords() xml succeeded, want error")
+	}
+}
@@ -0,0 +1,234 @@
+package itunes2subsonic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// ErrTooManySkipped is returned once a SkipCounter passes its limit.
+var ErrTooManySkipped = errors.New("too many skipped tracks")
+
+// SkipCounter tracks the songs that failed to update so that a misconfigured
+// run gives up early rather than failing on every song.
+type SkipCounter struct {
+	// Limit is the number of skips allowed. 0 means no limit.
+	Limit int
+	count int
+	// skipped are the updates skipped for each song, by key.
+	skipped map[string][]skippedUpdate
+}
+
+type skippedUpdate struct {
+	what string
+	err  error
+}
+
+// Skip reports that setting what (e.g., "rating") on the song at key failed
+// with err. Returns ErrTooManySkipped once Limit is exceeded.
+func (s *SkipCounter) Skip(key string, what string, err error) error {
+	fmt.Fprintf(os.Stderr, "Error setting %s for '%s': %s\n", what, key, err)
+	s.count++
+	if s.skipped == nil {
+		s.skipped = make(map[string][]skippedUpdate)
+	}
+	s.skipped[key] = append(s.skipped[key], skippedUpdate{what, err})
+	if s.Limit > 0 && s.count > s.Limit {
+		return ErrTooManySkipped
+	}
+	return nil
+}
+
+// Count returns the number of skipped songs.
+func (s *SkipCounter) Count() int {
+	return s.count
+}
+
+// Skipped returns whether anything was skipped for the song at key.
+func (s *SkipCounter) Skipped(key string) bool {
+	return len(s.skipped[key]) > 0
+}
+
+// SkippedWhat returns whether setting what (e.g., "rating") was skipped for
+// the song at key.
+func (s *SkipCounter) SkippedWhat(key, what string) bool {
+	for _, u := range s.skipped[key] {
+		if u.what == what {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors returns why each update of the song at key was skipped, e.g.,
+// "rating: 404 Not Found".
+func (s *SkipCounter) Errors(key string) []string {
+	var r []string
+	for _, u := range s.skipped[key] {
+		r = append(r, fmt.Sprintf("%s: %s", u.what, u.err))
+	}
+	return r
+}
+
+// songId returns the ID of s or an empty string if the song is missing.
+func songId(s SongInfo) string {
+	if s == nil {
+		return ""
+	}
+	return s.Id()
+}
+
+// PrintMissing writes the "Missing Tracks" section of the report. rootFlags
+// names the flags that set the library roots so the user can be pointed at
+// them.
+func PrintMissing(w io.Writer, p *Pairing, rootFlags string) {
+	fmt.Fprintln(w, "== Missing Tracks ==")
+	missing := p.Missing()
+	for _, v := range missing {
+		fmt.Fprintf(w, "%s\n\tmissing src(%s)\tdst(%s)\n", v.Key, songId(v.Src), songId(v.Dst))
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintf(w, "== Missing Track Count %d / (%d + %d) ==\n", len(missing), p.SrcCount, p.DstCount)
+
+	if p.MissingPercent() > 90 {
+		fmt.Fprintf(w, `Warning: Missing count is significant. Tips:
+* Verify that the libraries are configured for the same directory
+* Set %s, or --map, to the correct values
+* In Navidrome Player Settings, configure "Report Real Path"
+`, rootFlags)
+	}
+}
+
+// PrintMismatchedRatings writes the "Mismatched Ratings" section of the report.
+func PrintMismatchedRatings(w io.Writer, pairs []*SongPair) {
+	fmt.Fprintln(w, "== Mismatched Ratings ==")
+	for _, v := range pairs {
+		fmt.Fprintf(w, "%s\n\trating src(%d)\tdst(%d)\n", v.Key, v.Src.FiveStarRating(), v.Dst.FiveStarRating())
+	}
+	fmt.Fprintln(w, "")
+}
+
+// CopyRatings sets the src rating on dst for each of pairs.
+func CopyRatings(dst RatingSetter, pairs []*SongPair, skip *SkipCounter) error {
+	bar := PbWithOptions(pb.Default(int64(len(pairs)), "set rating"))
+	defer bar.Finish()
+	for _, v := range pairs {
+		err := dst.SetRating(v.Dst.Id(), v.Src.FiveStarRating())
+		bar.Add(1)
+		if err != nil {
+			if err := skip.Skip(v.Key, "rating", err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrintMismatchedStars writes the "Mismatched Stars" section of the report.
+func PrintMismatchedStars(w io.Writer, pairs []*SongPair) {
+	fmt.Fprintln(w, "== Mismatched Stars ==")
+	for _, v := range pairs {
+		src, _ := starred(v.Src)
+		dst, _ := starred(v.Dst)
+		fmt.Fprintf(w, "%s\n\tstarred src(%t)\tdst(%t)\n", v.Key, src, dst)
+	}
+	fmt.Fprintln(w, "")
+}
+
+// CopyStars stars or unstars dst to match src for each of pairs.
+func CopyStars(dst Starrer, pairs []*SongPair, skip *SkipCounter) error {
+	bar := PbWithOptions(pb.Default(int64(len(pairs)), "set star"))
+	defer bar.Finish()
+	for _, v := range pairs {
+		src, _ := starred(v.Src)
+		err := dst.SetStarred(v.Dst.Id(), src)
+		bar.Add(1)
+		if err != nil {
+			if err := skip.Skip(v.Key, "star", err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatTime formats t for the report.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// PrintStalePlayDates writes the "Mismatched Play Dates" section of the report.
+func PrintStalePlayDates(w io.Writer, pairs []*SongPair) {
+	fmt.Fprintln(w, "== Mismatched Play Dates ==")
+	for _, v := range pairs {
+		fmt.Fprintf(w, "%s\n\tplayed src(%s)\tdst(%s)\n", v.Key, formatTime(lastPlayed(v.Src)), formatTime(lastPlayed(v.Dst)))
+	}
+	fmt.Fprintln(w, "")
+}
+
+// CopyPlayDates records a play on dst at the src play time for each of pairs.
+func CopyPlayDates(dst PlayRecorder, pairs []*SongPair, skip *SkipCounter) error {
+	bar := PbWithOptions(pb.Default(int64(len(pairs)), "set play time"))
+	defer bar.Finish()
+	for _, v := range pairs {
+		err := dst.RecordPlay(v.Dst.Id(), lastPlayed(v.Src))
+		bar.Add(1)
+		if err != nil {
+			if err := skip.Skip(v.Key, "play time", err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrintMissingPlayCounts writes the "Mismatched Play Counts" section of the
+// report.
+func PrintMissingPlayCounts(w io.Writer, pairs []*SongPair) {
+	fmt.Fprintln(w, "== Mismatched Play Counts ==")
+	for _, v := range pairs {
+		fmt.Fprintf(w, "%s\n\tplays src(%d)\tdst(%d)\n", v.Key, playCount(v.Src), playCount(v.Dst))
+	}
+	fmt.Fprintln(w, "")
+}
+
+// CopyPlayCounts records the plays dst is missing for each of pairs. The
+// plays are backdated a minute apart so that the last lands on the src play
+// time, leaving the last played time correct as well.
+func CopyPlayCounts(dst PlayRecorder, pairs []*SongPair, skip *SkipCounter) error {
+	var total int64
+	for _, v := range pairs {
+		total += int64(playCount(v.Src) - playCount(v.Dst))
+	}
+
+	bar := PbWithOptions(pb.Default(total, "set play count"))
+	defer bar.Finish()
+	for _, v := range pairs {
+		last := lastPlayed(v.Src)
+		delta := playCount(v.Src) - playCount(v.Dst)
+		for i := delta - 1; i >= 0; i-- {
+			var t time.Time
+			if !last.IsZero() {
+				t = last.Add(-time.Duration(i) * time.Minute)
+			}
+
+			err := dst.RecordPlay(v.Dst.Id(), t)
+			bar.Add(1)
+			if err != nil {
+				if err := skip.Skip(v.Key, "play count", err); err != nil {
+					return err
+				}
+				// Don't hammer the server with the rest of a failing song.
+				break
+			}
+		}
+	}
+	return nil
+}
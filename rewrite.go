@@ -0,0 +1,137 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// RewriteRule is a regular expression substitution applied to the paths of
+// src songs, dst songs or both before pairing, e.g., to undo a tagger renaming
+// "01 - Title.mp3" to "01-_Title_.mp3".
+type RewriteRule struct {
+	Src, Dst    bool
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseRewriteRule parses a rule written as "pattern=>replacement". It applies
+// to both libraries unless prefixed with "src:" or "dst:". The replacement
+// can refer to the groups of the pattern, e.g., "$1" or "${name}".
+func ParseRewriteRule(s string) (RewriteRule, error) {
+	r := RewriteRule{Src: true, Dst: true}
+	switch {
+	case strings.HasPrefix(s, "src:"):
+		r.Dst, s = false, s[len("src:"):]
+	case strings.HasPrefix(s, "dst:"):
+		r.Src, s = false, s[len("dst:"):]
+	}
+	i := strings.Index(s, "=>")
+	if i < 0 {
+		return RewriteRule{}, fmt.Errorf("bad rewrite rule '%s', want '[src:|dst:]pattern=>replacement'", s)
+	}
+	re, err := regexp.Compile(s[:i])
+	if err != nil {
+		return RewriteRule{}, fmt.Errorf("bad rewrite pattern '%s': %w", s[:i], err)
+	}
+	r.Pattern, r.Replacement = re, s[i+len("=>"):]
+	return r, nil
+}
+
+func (r RewriteRule) String() string {
+	side := ""
+	switch {
+	case !r.Dst:
+		side = "src:"
+	case !r.Src:
+		side = "dst:"
+	}
+	return side + r.Pattern.String() + "=>" + r.Replacement
+}
+
+// applies returns whether r rewrites dst paths if dst is set, or src paths.
+func (r RewriteRule) applies(dst bool) bool {
+	if dst {
+		return r.Dst
+	}
+	return r.Src
+}
+
+// RewritePath applies the rules, in order, to a src path, or a dst path if
+// dst is set.
+func RewritePath(rules []RewriteRule, path string, dst bool) string {
+	for _, r := range rules {
+		if r.applies(dst) {
+			path = r.Pattern.ReplaceAllString(path, r.Replacement)
+		}
+	}
+	return path
+}
+
+// ExplainPath returns each step taken to turn a src path, or a dst path if dst
+// is set, into the key it's paired by.
+func (p *Pairing) ExplainPath(path string, dst bool) []string {
+	steps := []string{"path " + path}
+	for _, r := range p.Rewrites {
+		if !r.applies(dst) {
+			continue
+		}
+		if rewritten := r.Pattern.ReplaceAllString(path, r.Replacement); rewritten != path {
+			path = rewritten
+			steps = append(steps, fmt.Sprintf("rewrite '%s' %s", r, path))
+		} else {
+			steps = append(steps, fmt.Sprintf("rewrite '%s' no match", r))
+		}
+	}
+	steps = append(steps, "canonical "+CanonicalPath(path))
+	if i := rootIndex(path, p.Roots, dst); i >= 0 {
+		steps = append(steps, "root "+p.Roots[i].String())
+	} else {
+		steps = append(steps, "root none")
+	}
+	return append(steps, "key "+p.key(path, dst))
+}
+
+// maxExplained limits the songs PrintExplainPath describes.
+const maxExplained = 10
+
+// PrintExplainPath writes the "Explain Path" section of the report, showing
+// how the songs with the ID want, or a path containing it, were paired.
+func PrintExplainPath(w io.Writer, p *Pairing, want string) {
+	fmt.Fprintln(w, "== Explain Path ==")
+	canonical := CanonicalPath(want)
+	found := 0
+	explain := func(v *SongPair, s SongInfo, dst bool) {
+		if s == nil || (s.Id() != want && !strings.Contains(CanonicalPath(s.Path()), canonical)) {
+			return
+		}
+		found++
+		if found > maxExplained {
+			return
+		}
+
+		side, otherSide, other := "src", "dst", v.Dst
+		if dst {
+			side, otherSide, other = "dst", "src", v.Src
+		}
+		steps := p.ExplainPath(s.Path(), dst)
+		if other == nil {
+			steps = append(steps, "missing from "+otherSide)
+		} else {
+			steps = append(steps, fmt.Sprintf("paired with %s by %s", songId(other), v.Strategy))
+		}
+		fmt.Fprintf(w, "%s(%s)\n\t%s\n", side, s.Id(), strings.Join(steps, "\n\t"))
+	}
+	for _, v := range p.Pairs {
+		explain(v, v.Src, false)
+		explain(v, v.Dst, true)
+	}
+	fmt.Fprintln(w, "")
+
+	if found == 0 {
+		fmt.Fprintf(w, "Note: no song with ID or path '%s' found\n", want)
+	} else if found > maxExplained {
+		fmt.Fprintf(w, "Note: %d songs match '%s', showing the first %d\n", found, want, maxExplained)
+	}
+}
@@ -0,0 +1,104 @@
+package itunes2subsonic
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRewriteRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		src, dst bool
+		pattern  string
+	}{
+		{`(\d+) - (.*)\.mp3$=>${1}-_${2}_.mp3`, true, true, `(\d+) - (.*)\.mp3$`},
+		{`src:\.m4a$=>.mp3`, true, false, `\.m4a$`},
+		{`dst:^/srv=>`, false, true, `^/srv`},
+	}
+	for _, test := range tests {
+		r, err := ParseRewriteRule(test.rule)
+		if err != nil {
+			t.Errorf("ParseRewriteRule(%s) failed: %s", test.rule, err)
+			continue
+		}
+		if r.Src != test.src || r.Dst != test.dst || r.Pattern.String() != test.pattern {
+			t.Errorf("ParseRewriteRule(%s) = %+v", test.rule, r)
+		}
+		if r.String() != test.rule {
+			t.Errorf("RewriteRule.String() = %s, want %s", r, test.rule)
+		}
+	}
+
+	for _, bad := range []string{`\.m4a$`, `src:(=>x`} {
+		if _, err := ParseRewriteRule(bad); err == nil {
+			t.Errorf("ParseRewriteRule(%s) succeeded, want error", bad)
+		}
+	}
+}
+
+func mustRewriteRules(t *testing.T, rules ...string) []RewriteRule {
+	var r []RewriteRule
+	for _, s := range rules {
+		rule, err := ParseRewriteRule(s)
+		if err != nil {
+			t.Fatalf("ParseRewriteRule(%s) failed: %s", s, err)
+		}
+		r = append(r, rule)
+	}
+	return r
+}
+
+func TestNewPairingRewrites(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", `/a/Rush/2112/01 - 2112.mp3`, 0},
+		testSong{"2", `/a/Rush/2112/02 - A Passage To Bangkok.m4a`, 0},
+		testSong{"3", `/a/Rush/2112/03-_The_Twilight_Zone_.mp3`, 0},
+	}
+	dst := []SongInfo{
+		testSong{"a", `/b/Rush/2112/01-_2112_.mp3`, 0},
+		testSong{"b", `/b/Rush/2112/02-_A Passage To Bangkok_.mp3`, 0},
+		testSong{"c", `/b/Rush/2112/04-_Lessons_.mp3`, 0},
+	}
+	rules := mustRewriteRules(t,
+		`src:\.m4a$=>.mp3`,
+		`src:/(\d+) - ([^/]*)\.mp3$=>/${1}-_${2}_.mp3`,
+	)
+
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}, Rewrites: rules})
+	got := make(map[string]string)
+	for _, v := range p.Matched() {
+		got[v.Src.Id()] = v.Dst.Id()
+	}
+	if want := map[string]string{"1": "a", "2": "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Matched() = %v, want %v", got, want)
+	}
+
+	want := []string{
+		"path /a/Rush/2112/02 - A Passage To Bangkok.m4a",
+		`rewrite 'src:\.m4a$=>.mp3' /a/Rush/2112/02 - A Passage To Bangkok.mp3`,
+		`rewrite 'src:/(\d+) - ([^/]*)\.mp3$=>/${1}-_${2}_.mp3' /a/Rush/2112/02-_A Passage To Bangkok_.mp3`,
+		"canonical /a/rush/2112/02-_a passage to bangkok_.mp3",
+		"root src='/a/' dst='/b/'",
+		"key rush/2112/02-_a passage to bangkok_.mp3",
+	}
+	if got := p.ExplainPath(src[1].Path(), false); !reflect.DeepEqual(got, want) {
+		t.Errorf("ExplainPath() = %q, want %q", got, want)
+	}
+	// Only the src rules apply to dst.
+	if got := p.ExplainPath(dst[2].Path(), true); len(got) != 4 {
+		t.Errorf("ExplainPath(dst) = %q, want no rewrites", got)
+	}
+
+	var b bytes.Buffer
+	PrintExplainPath(&b, p, "twilight")
+	if s := b.String(); !strings.Contains(s, "src(3)\n") || !strings.Contains(s, "\tmissing from dst\n") {
+		t.Errorf("PrintExplainPath() = %s", s)
+	}
+	b.Reset()
+	PrintExplainPath(&b, p, "a")
+	if s := b.String(); !strings.Contains(s, "dst(a)\n") || !strings.Contains(s, "\tpaired with 1 by path\n") {
+		t.Errorf("PrintExplainPath() = %s", s)
+	}
+}
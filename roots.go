@@ -0,0 +1,103 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RootMapping is a library prefix in src and the prefix of the same folder in
+// dst, e.g., "file://localhost/M:/Music/" and "/music/".
+type RootMapping struct {
+	SrcRoot, DstRoot string
+}
+
+func (m RootMapping) String() string {
+	return fmt.Sprintf("src='%s' dst='%s'", m.SrcRoot, m.DstRoot)
+}
+
+// ParseRootMapping parses a mapping written as "src=dst". The src root can't
+// contain '='.
+func ParseRootMapping(s string) (RootMapping, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return RootMapping{}, fmt.Errorf("bad root mapping '%s', want 'src=dst'", s)
+	}
+	return RootMapping{SrcRoot: s[:i], DstRoot: s[i+1:]}, nil
+}
+
+// rootIndex returns the index of the mapping whose root is the longest prefix
+// of path, comparing the dst roots if dst is set. Returns -1 if none are.
+func rootIndex(path string, roots []RootMapping, dst bool) int {
+	path = CanonicalPath(path)
+	best, bestLen := -1, -1
+	for i, m := range roots {
+		r := m.SrcRoot
+		if dst {
+			r = m.DstRoot
+		}
+		r = CanonicalPath(r)
+		if len(r) > bestLen && strings.HasPrefix(path, r) {
+			best, bestLen = i, len(r)
+		}
+	}
+	return best
+}
+
+// RootStats counts the songs found under one of the Pairing roots.
+type RootStats struct {
+	RootMapping
+	// Unmapped is set for the songs under none of the roots.
+	Unmapped bool
+	// Src and Dst are the songs under the root in each library, and Matched
+	// the src songs that were paired.
+	Src, Dst, Matched int
+}
+
+// RootStats returns the songs found under each of the roots, followed by the
+// songs under none of them if there are any.
+func (p *Pairing) RootStats() []RootStats {
+	stats := make([]RootStats, len(p.Roots)+1)
+	for i, m := range p.Roots {
+		stats[i].RootMapping = m
+	}
+	unmapped := &stats[len(p.Roots)]
+	unmapped.Unmapped = true
+
+	at := func(i int) *RootStats {
+		if i < 0 {
+			return unmapped
+		}
+		return &stats[i]
+	}
+	for _, v := range p.Pairs {
+		if v.Src != nil {
+			s := at(rootIndex(p.path(v.Src, false), p.Roots, false))
+			s.Src++
+			if v.Paired() {
+				s.Matched++
+			}
+		}
+		if v.Dst != nil {
+			at(rootIndex(p.path(v.Dst, true), p.Roots, true)).Dst++
+		}
+	}
+
+	if unmapped.Src == 0 && unmapped.Dst == 0 {
+		stats = stats[:len(p.Roots)]
+	}
+	return stats
+}
+
+// PrintRootStats writes the "Library Roots" section of the report.
+func PrintRootStats(w io.Writer, p *Pairing) {
+	fmt.Fprintln(w, "== Library Roots ==")
+	for _, s := range p.RootStats() {
+		name := s.RootMapping.String()
+		if s.Unmapped {
+			name = "unmapped"
+		}
+		fmt.Fprintf(w, "%s\n\tsongs src(%d)\tdst(%d)\tmatched(%d)\n", name, s.Src, s.Dst, s.Matched)
+	}
+	fmt.Fprintln(w, "")
+}
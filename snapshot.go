@@ -0,0 +1,231 @@
+package itunes2subsonic
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// The formats a Snapshot can be saved in, as set in SyncOptions.BackupFormat.
+const (
+	SnapshotJson = "json"
+	SnapshotCsv  = "csv"
+)
+
+// ValidSnapshotFormat returns whether format is one of the Snapshot formats.
+func ValidSnapshotFormat(format string) bool {
+	return format == SnapshotJson || format == SnapshotCsv
+}
+
+// SnapshotSong is the state of a song saved in a Snapshot.
+type SnapshotSong struct {
+	Id     string `json:"id"`
+	Path   string `json:"path"`
+	Rating int    `json:"rating"`
+	// Starred is nil if the library doesn't know.
+	Starred   *bool `json:"starred,omitempty"`
+	PlayCount int   `json:"play_count"`
+	// LastPlayed is zero if never played or unknown.
+	LastPlayed time.Time `json:"last_played"`
+}
+
+// Snapshot is the ratings, stars and plays of every song in a library at one
+// time, saved before the library is modified so it can be restored. A loaded
+// Snapshot is a Source, so it's restored by syncing it to the library.
+type Snapshot struct {
+	Library string         `json:"library"`
+	Time    time.Time      `json:"time"`
+	Entries []SnapshotSong `json:"songs"`
+
+	// path is where the snapshot was loaded from.
+	path string
+}
+
+// NewSnapshot returns a snapshot of songs, the songs of the library named
+// library.
+func NewSnapshot(library string, songs []SongInfo) *Snapshot {
+	s := &Snapshot{Library: library, Time: time.Now().UTC()}
+	for _, v := range songs {
+		song := SnapshotSong{
+			Id:         v.Id(),
+			Path:       v.Path(),
+			Rating:     v.FiveStarRating(),
+			PlayCount:  playCount(v),
+			LastPlayed: lastPlayed(v).UTC(),
+		}
+		if st, ok := starred(v); ok {
+			song.Starred = &st
+		}
+		s.Entries = append(s.Entries, song)
+	}
+	return s
+}
+
+// unsafeFileChars matches the characters of a library name kept out of file
+// names.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Save writes the snapshot in format to a new file in dir, created if needed,
+// named after the library and time. Returns the path of the file.
+func (s *Snapshot) Save(dir, format string) (string, error) {
+	if !ValidSnapshotFormat(format) {
+		return "", fmt.Errorf("unknown snapshot format '%s'", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s.%s", strings.Trim(unsafeFileChars.ReplaceAllString(s.Library, "_"), "_"), s.Time.UTC().Format("20060102T150405Z"), format)
+	path := filepath.Join(dir, name)
+
+	// Written to a temporary file first so an interrupted save leaves nothing
+	// that looks like a whole snapshot.
+	f, err := ioutil.TempFile(dir, name+".*")
+	if err != nil {
+		return "", err
+	}
+	if format == SnapshotCsv {
+		err = s.writeCsv(f)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(s)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return path, os.Rename(f.Name(), path)
+}
+
+var snapshotCsvHeader = []string{"id", "path", "rating", "starred", "play_count", "last_played"}
+
+func (s *Snapshot) writeCsv(w io.Writer) error {
+	c := csv.NewWriter(w)
+	if err := c.Write(snapshotCsvHeader); err != nil {
+		return err
+	}
+	for _, v := range s.Entries {
+		st, played := "", ""
+		if v.Starred != nil {
+			st = strconv.FormatBool(*v.Starred)
+		}
+		if !v.LastPlayed.IsZero() {
+			played = v.LastPlayed.Format(time.RFC3339)
+		}
+		if err := c.Write([]string{v.Id, v.Path, strconv.Itoa(v.Rating), st, strconv.Itoa(v.PlayCount), played}); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// LoadSnapshot reads a snapshot saved by Save, in the format given by the
+// file extension. A CSV snapshot doesn't record the library or time.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Snapshot{path: path}
+	if strings.EqualFold(filepath.Ext(path), "."+SnapshotCsv) {
+		err = s.readCsv(f)
+	} else {
+		err = json.NewDecoder(f).Decode(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot '%s': %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *Snapshot) readCsv(r io.Reader) error {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = len(snapshotCsvHeader)
+	rows, err := c.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(snapshotCsvHeader, ",") {
+		return fmt.Errorf("header should be %s", strings.Join(snapshotCsvHeader, ","))
+	}
+	for i, row := range rows[1:] {
+		v := SnapshotSong{Id: row[0], Path: row[1]}
+		if v.Rating, err = strconv.Atoi(row[2]); err != nil {
+			return fmt.Errorf("line %d: %w", i+2, err)
+		}
+		if row[3] != "" {
+			st, err := strconv.ParseBool(row[3])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", i+2, err)
+			}
+			v.Starred = &st
+		}
+		if v.PlayCount, err = strconv.Atoi(row[4]); err != nil {
+			return fmt.Errorf("line %d: %w", i+2, err)
+		}
+		if row[5] != "" {
+			if v.LastPlayed, err = time.Parse(time.RFC3339, row[5]); err != nil {
+				return fmt.Errorf("line %d: %w", i+2, err)
+			}
+		}
+		s.Entries = append(s.Entries, v)
+	}
+	return nil
+}
+
+// Name implements Source.
+func (s *Snapshot) Name() string {
+	return "snapshot:" + s.path
+}
+
+// Songs implements Source.
+func (s *Snapshot) Songs(bar *pb.ProgressBar) ([]SongInfo, error) {
+	var songs []SongInfo
+	for i := range s.Entries {
+		v := &s.Entries[i]
+		if v.Starred != nil {
+			songs = append(songs, starredSnapshotSong{snapshotSong{v}})
+		} else {
+			songs = append(songs, snapshotSong{v})
+		}
+	}
+	if bar != nil {
+		bar.Add(len(songs))
+	}
+	return songs, nil
+}
+
+type snapshotSong struct {
+	s *SnapshotSong
+}
+
+func (s snapshotSong) Id() string            { return s.s.Id }
+func (s snapshotSong) Path() string          { return s.s.Path }
+func (s snapshotSong) FiveStarRating() int   { return s.s.Rating }
+func (s snapshotSong) PlayCount() int        { return s.s.PlayCount }
+func (s snapshotSong) LastPlayed() time.Time { return s.s.LastPlayed }
+
+// starredSnapshotSong is a snapshotSong from a library that knows stars.
+type starredSnapshotSong struct {
+	snapshotSong
+}
+
+func (s starredSnapshotSong) Starred() bool { return *s.s.Starred }
@@ -0,0 +1,67 @@
+package itunes2subsonic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	played := time.Date(2022, 12, 18, 8, 8, 49, 0, time.UTC)
+	songs := []SongInfo{
+		testPlayedSong{testSong{"1", "/b/1.mp3", 5}, played, 3},
+		testStarredSong{testSong{"2", "/b/2, \"live\".mp3", 0}, true},
+	}
+	snap := NewSnapshot("subsonic:https://example.com", songs)
+
+	for _, format := range []string{SnapshotJson, SnapshotCsv} {
+		path, err := snap.Save(dir, format)
+		if err != nil {
+			t.Fatalf("%s: Save() failed: %s", format, err)
+		}
+		if name := filepath.Base(path); !strings.HasPrefix(name, "subsonic_https_example.com-") || !strings.HasSuffix(name, "."+format) {
+			t.Errorf("%s: Save() path = %s", format, path)
+		}
+
+		loaded, err := LoadSnapshot(path)
+		if err != nil {
+			t.Fatalf("%s: LoadSnapshot() failed: %s", format, err)
+		}
+		if !reflect.DeepEqual(loaded.Entries, snap.Entries) {
+			t.Errorf("%s: LoadSnapshot() = %+v, want %+v", format, loaded.Entries, snap.Entries)
+		}
+		if loaded.Name() != "snapshot:"+path {
+			t.Errorf("%s: Name() = %s", format, loaded.Name())
+		}
+
+		got, _ := loaded.Songs(nil)
+		if len(got) != 2 || playCount(got[0]) != 3 || !lastPlayed(got[0]).Equal(played) {
+			t.Fatalf("%s: Songs() = %+v", format, got)
+		}
+		if _, ok := starred(got[0]); ok {
+			t.Errorf("%s: Songs()[0] knows stars, want unknown", format)
+		}
+		if st, ok := starred(got[1]); !ok || !st {
+			t.Errorf("%s: Songs()[1] starred = %t, %t, want starred", format, st, ok)
+		}
+	}
+
+	if _, err := snap.Save(dir, "xml"); err == nil {
+		t.Errorf("Save() as xml succeeded, want error")
+	}
+	bad := filepath.Join(dir, "bad.csv")
+	ioutil.WriteFile(bad, []byte("id,path\n1,/b/1.mp3\n"), 0644)
+	if _, err := LoadSnapshot(bad); err == nil {
+		t.Errorf("LoadSnapshot() of bad file succeeded, want error")
+	}
+}
@@ -0,0 +1,76 @@
+package itunes2subsonic
+
+import (
+	"strings"
+	"time"
+)
+
+// SongInfo is the minimal view of a track needed to pair and sync libraries.
+type SongInfo interface {
+	// Id is the identifier used by the owning library.
+	Id() string
+	// Path is the location of the file as reported by the owning library.
+	Path() string
+	// FiveStarRating is the rating in the range 0-5, where 0 is unrated.
+	FiveStarRating() int
+}
+
+// SongMetadata may optionally be implemented by a SongInfo that knows its
+// tags. Zero values indicate the library didn't provide the field.
+type SongMetadata interface {
+	Title() string
+	Artist() string
+	AlbumArtist() string
+	Album() string
+	TrackNumber() int
+	DiscNumber() int
+	Duration() time.Duration
+	Size() int64
+}
+
+// MetadataOf returns the SongMetadata of s if it provides any.
+func MetadataOf(s SongInfo) (SongMetadata, bool) {
+	m, ok := s.(SongMetadata)
+	return m, ok
+}
+
+// SongPlays may optionally be implemented by a SongInfo that tracks plays.
+type SongPlays interface {
+	PlayCount() int
+	// LastPlayed is zero if the song was never played or the library doesn't
+	// know when.
+	LastPlayed() time.Time
+}
+
+// SongDates may optionally be implemented by a SongInfo that knows when it was
+// added to the library.
+type SongDates interface {
+	DateAdded() time.Time
+}
+
+// SongModified may optionally be implemented by a SongInfo that knows when its
+// file last changed.
+type SongModified interface {
+	DateModified() time.Time
+}
+
+// SongStars may optionally be implemented by a SongInfo that can be marked as
+// a favorite (iTunes "Loved", Subsonic "starred").
+type SongStars interface {
+	Starred() bool
+}
+
+// SongMusicBrainz may optionally be implemented by a SongInfo that knows its
+// MusicBrainz recording ID. An empty ID means it's unknown.
+type SongMusicBrainz interface {
+	MusicBrainzId() string
+}
+
+// musicBrainzId returns the lower case MusicBrainz recording ID of s, or an
+// empty string if unknown.
+func musicBrainzId(s SongInfo) string {
+	if m, ok := s.(SongMusicBrainz); ok {
+		return strings.ToLower(strings.TrimSpace(m.MusicBrainzId()))
+	}
+	return ""
+}
@@ -0,0 +1,391 @@
+package itunes2subsonic
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	// A pure Go driver, so builds don't need cgo.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteDriver is the database/sql driver name of the SQLite databases read
+// and written, registered by the import above.
+const sqliteDriver = "sqlite"
+
+// stateSchema upgrades a StateDb one version at a time. The version of a
+// database is the number of entries applied, kept in PRAGMA user_version.
+var stateSchema = []string{
+	`CREATE TABLE runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		src TEXT NOT NULL,
+		dst TEXT NOT NULL,
+		started INTEGER NOT NULL,
+		finished INTEGER,
+		pushed INTEGER NOT NULL DEFAULT 0,
+		skipped INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE pairs (
+		src TEXT NOT NULL,
+		dst TEXT NOT NULL,
+		key TEXT NOT NULL,
+		src_id TEXT NOT NULL,
+		dst_id TEXT NOT NULL,
+		seen_run INTEGER NOT NULL REFERENCES runs(id),
+		src_rating INTEGER NOT NULL,
+		dst_rating INTEGER NOT NULL,
+		src_starred INTEGER,
+		dst_starred INTEGER,
+		src_play_count INTEGER NOT NULL,
+		dst_play_count INTEGER NOT NULL,
+		src_last_played INTEGER,
+		dst_last_played INTEGER,
+		pushed_rating INTEGER,
+		rating_run INTEGER REFERENCES runs(id),
+		pushed_starred INTEGER,
+		starred_run INTEGER REFERENCES runs(id),
+		pushed_play_count INTEGER,
+		play_count_run INTEGER REFERENCES runs(id),
+		pushed_last_played INTEGER,
+		last_played_run INTEGER REFERENCES runs(id),
+		PRIMARY KEY (src, dst, key)
+	);`,
+	`CREATE TABLE two_way_ratings (
+		src TEXT NOT NULL,
+		dst TEXT NOT NULL,
+		key TEXT NOT NULL,
+		rating INTEGER NOT NULL,
+		PRIMARY KEY (src, dst, key)
+	);`,
+	`CREATE TABLE journal (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		run INTEGER NOT NULL REFERENCES runs(id),
+		library TEXT NOT NULL,
+		src INTEGER NOT NULL,
+		song_id TEXT NOT NULL,
+		path TEXT NOT NULL,
+		field TEXT NOT NULL,
+		old INTEGER NOT NULL,
+		new INTEGER NOT NULL,
+		time INTEGER NOT NULL
+	);
+	CREATE INDEX journal_run ON journal (run);`,
+}
+
+// StateDb is the local record of past syncs between a src and dst library,
+// kept in an SQLite database.
+//
+// Each run is recorded along with, for each matched pair, the IDs and values
+// last seen in both libraries and the src values last pushed to dst, and a
+// journal of the values it changed. Two-way syncs keep their RatingState too.
+type StateDb struct {
+	db       *sql.DB
+	src, dst string
+}
+
+// OpenStateDb opens, creating if needed, the database at path for syncs from
+// the library named src to the one named dst.
+func OpenStateDb(path, src, dst string) (*StateDb, error) {
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	s := &StateDb{db: db, src: src, dst: dst}
+	if err := s.upgrade(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open state database '%s': %w", path, err)
+	}
+	return s, nil
+}
+
+// OpenStateDbReadOnly opens the existing database at path for syncs from the
+// library named src to the one named dst without writing to it, e.g., for a
+// dry run. It must be up to date.
+func OpenStateDbReadOnly(path, src, dst string) (*StateDb, error) {
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	s := &StateDb{db: db, src: src, dst: dst}
+	version, err := s.version()
+	if err == nil && version != len(stateSchema) {
+		err = fmt.Errorf("version %d needs upgrading to %d by a run that isn't a dry run", version, len(stateSchema))
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open state database '%s': %w", path, err)
+	}
+	return s, nil
+}
+
+// version returns the number of stateSchema entries applied to the database.
+func (s *StateDb) version() (int, error) {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > len(stateSchema) {
+		return 0, fmt.Errorf("version %d is newer than this program (%d)", version, len(stateSchema))
+	}
+	return version, nil
+}
+
+// upgrade applies the stateSchema entries the database is missing.
+func (s *StateDb) upgrade() error {
+	version, err := s.version()
+	if err != nil {
+		return err
+	}
+	for ; version < len(stateSchema); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stateSchema[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// PRAGMA doesn't take parameters.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *StateDb) Close() error {
+	return s.db.Close()
+}
+
+// BeginRun records the start of a run and returns its ID. Dry runs aren't
+// recorded.
+func (s *StateDb) BeginRun() (int64, error) {
+	return s.beginRun(s.src, s.dst)
+}
+
+// beginRun is BeginRun for a run between other libraries, e.g., an undo.
+func (s *StateDb) beginRun(src, dst string) (int64, error) {
+	r, err := s.db.Exec("INSERT INTO runs (src, dst, started) VALUES (?, ?, ?)",
+		src, dst, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return r.LastInsertId()
+}
+
+// FinishRun records the end of run, having pushed updates to dst and skipped
+// those that failed.
+func (s *StateDb) FinishRun(run int64, pushed, skipped int) error {
+	_, err := s.db.Exec("UPDATE runs SET finished = ?, pushed = ?, skipped = ? WHERE id = ?",
+		time.Now().Unix(), pushed, skipped, run)
+	return err
+}
+
+// StateRun is a run recorded in a StateDb.
+type StateRun struct {
+	Id       int64
+	Src, Dst string
+	// Finished is zero if the run didn't finish.
+	Started, Finished time.Time
+	Pushed, Skipped   int
+}
+
+// Runs returns every run recorded, oldest first, whatever the libraries.
+func (s *StateDb) Runs() ([]StateRun, error) {
+	rows, err := s.db.Query("SELECT id, src, dst, started, finished, pushed, skipped FROM runs ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []StateRun
+	for rows.Next() {
+		var r StateRun
+		var started int64
+		var finished sql.NullInt64
+		if err := rows.Scan(&r.Id, &r.Src, &r.Dst, &started, &finished, &r.Pushed, &r.Skipped); err != nil {
+			return nil, err
+		}
+		r.Started = time.Unix(started, 0)
+		if finished.Valid {
+			r.Finished = time.Unix(finished.Int64, 0)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// PrintRuns writes the "Sync Runs" section of the report.
+func PrintRuns(w io.Writer, runs []StateRun) {
+	fmt.Fprintln(w, "== Sync Runs ==")
+	for _, r := range runs {
+		finished := "never"
+		if !r.Finished.IsZero() {
+			finished = r.Finished.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\n\tsrc(%s)\tdst(%s)\n\tstarted(%s)\tfinished(%s)\tpushed(%d)\tskipped(%d)\n",
+			r.Id, r.Src, r.Dst, r.Started.Format(time.RFC3339), finished, r.Pushed, r.Skipped)
+	}
+	fmt.Fprintln(w, "")
+}
+
+// songState is the values of a song that a StateDb keeps. Starred and
+// LastPlayed are nil if the library doesn't know them.
+type songState struct {
+	rating, playCount   int64
+	starred, lastPlayed interface{}
+}
+
+func stateOf(s SongInfo) songState {
+	v := songState{rating: int64(s.FiveStarRating()), playCount: int64(playCount(s))}
+	if st, ok := starred(s); ok {
+		v.starred = st
+	}
+	if t := lastPlayed(s); !t.IsZero() {
+		v.lastPlayed = t.Unix()
+	}
+	return v
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// inTx runs f in a transaction, committing if it succeeds.
+func (s *StateDb) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordSeen records the IDs and values of the matched pairs as seen in run.
+func (s *StateDb) RecordSeen(run int64, pairs []*SongPair) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO pairs (src, dst, key, src_id, dst_id, seen_run,
+				src_rating, dst_rating, src_starred, dst_starred, src_play_count, dst_play_count, src_last_played, dst_last_played)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (src, dst, key) DO UPDATE SET
+				src_id = excluded.src_id, dst_id = excluded.dst_id, seen_run = excluded.seen_run,
+				src_rating = excluded.src_rating, dst_rating = excluded.dst_rating,
+				src_starred = excluded.src_starred, dst_starred = excluded.dst_starred,
+				src_play_count = excluded.src_play_count, dst_play_count = excluded.dst_play_count,
+				src_last_played = excluded.src_last_played, dst_last_played = excluded.dst_last_played`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, v := range pairs {
+			src, dst := stateOf(v.Src), stateOf(v.Dst)
+			if _, err := stmt.Exec(s.src, s.dst, v.Key, v.Src.Id(), v.Dst.Id(), run,
+				src.rating, dst.rating, src.starred, dst.starred, src.playCount, dst.playCount, src.lastPlayed, dst.lastPlayed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// pushedColumns are the columns holding the src value last pushed of each
+// update, e.g., UpdateRating, and the run that pushed it.
+var pushedColumns = map[string][2]string{
+	UpdateRating:    {"pushed_rating", "rating_run"},
+	UpdateStar:      {"pushed_starred", "starred_run"},
+	UpdatePlayCount: {"pushed_play_count", "play_count_run"},
+	UpdatePlayTime:  {"pushed_last_played", "last_played_run"},
+}
+
+// value returns the value of update kept in pushedColumns.
+func (v songState) value(update string) interface{} {
+	switch update {
+	case UpdateStar:
+		return v.starred
+	case UpdatePlayCount:
+		return v.playCount
+	case UpdatePlayTime:
+		return v.lastPlayed
+	}
+	return v.rating
+}
+
+// RecordPushed records that dst was brought in line with the src values of
+// pairs in run, for each of the updates given for the pair, e.g.,
+// UpdateRating. The pairs must have been recorded by RecordSeen.
+func (s *StateDb) RecordPushed(run int64, pushed map[*SongPair][]string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		stmts := make(map[string]*sql.Stmt)
+		for update, c := range pushedColumns {
+			stmt, err := tx.Prepare(fmt.Sprintf("UPDATE pairs SET %s = ?, %s = ? WHERE src = ? AND dst = ? AND key = ?", c[0], c[1]))
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+			stmts[update] = stmt
+		}
+		for v, updates := range pushed {
+			src := stateOf(v.Src)
+			for _, u := range updates {
+				stmt, ok := stmts[u]
+				if !ok {
+					return fmt.Errorf("unknown update '%s'", u)
+				}
+				if _, err := stmt.Exec(src.value(u), run, s.src, s.dst, v.Key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Unchanged returns the pairs whose src value of update, e.g., UpdateRating,
+// is the same as when it was last pushed, so dst needs nothing new from src.
+func (s *StateDb) Unchanged(update string, pairs []*SongPair) (map[*SongPair]bool, error) {
+	c, ok := pushedColumns[update]
+	if !ok {
+		return nil, fmt.Errorf("unknown update '%s'", update)
+	}
+	stmt, err := s.db.Prepare(fmt.Sprintf("SELECT %s FROM pairs WHERE src = ? AND dst = ? AND key = ? AND src_id = ? AND %s IS NOT NULL", c[0], c[1]))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	unchanged := make(map[*SongPair]bool)
+	for _, v := range pairs {
+		var last sql.NullInt64
+		err := stmt.QueryRow(s.src, s.dst, v.Key, v.Src.Id()).Scan(&last)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Starred and last played are nil when unknown.
+		var now sql.NullInt64
+		switch x := stateOf(v.Src).value(update).(type) {
+		case int64:
+			now = sql.NullInt64{Int64: x, Valid: true}
+		case bool:
+			now = sql.NullInt64{Int64: int64(boolInt(x)), Valid: true}
+		}
+		if now == last {
+			unchanged[v] = true
+		}
+	}
+	return unchanged, nil
+}
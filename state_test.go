@@ -0,0 +1,109 @@
+package itunes2subsonic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateDb(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.db")
+
+	played := time.Date(2022, 12, 18, 8, 8, 49, 0, time.UTC)
+	src := []SongInfo{
+		testPlayedSong{testSong{"1", "/a/1.mp3", 5}, played, 3},
+		testStarredSong{testSong{"2", "/a/2.mp3", 4}, true},
+		testSong{"3", "/a/3.mp3", 3},
+	}
+	dst := []SongInfo{
+		testSong{"a", "/b/1.mp3", 0},
+		testSong{"b", "/b/2.mp3", 0},
+		testSong{"c", "/b/3.mp3", 0},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+	matched := p.Matched()
+
+	db, err := OpenStateDb(path, "itunes:lib.xml", "subsonic:http://example.com")
+	if err != nil {
+		t.Fatalf("OpenStateDb() failed: %s", err)
+	}
+	run, err := db.BeginRun()
+	if err != nil {
+		t.Fatalf("BeginRun() failed: %s", err)
+	}
+	if err := db.RecordSeen(run, matched); err != nil {
+		t.Fatalf("RecordSeen() failed: %s", err)
+	}
+	if got, err := db.Unchanged(UpdateRating, matched); err != nil || len(got) != 0 {
+		t.Errorf("Unchanged() before push = %v, %v, want none", got, err)
+	}
+	// The third song failed to update, and the plays of the second weren't
+	// copied.
+	all := []string{UpdateRating, UpdateStar, UpdatePlayCount, UpdatePlayTime}
+	pushed := map[*SongPair][]string{matched[0]: all, matched[1]: {UpdateRating, UpdateStar}}
+	if err := db.RecordPushed(run, pushed); err != nil {
+		t.Fatalf("RecordPushed() failed: %s", err)
+	}
+	if err := db.FinishRun(run, 2, 1); err != nil {
+		t.Fatalf("FinishRun() failed: %s", err)
+	}
+	db.Close()
+
+	// Reopening keeps the state and doesn't recreate the tables.
+	db, err = OpenStateDb(path, "itunes:lib.xml", "subsonic:http://example.com")
+	if err != nil {
+		t.Fatalf("OpenStateDb() again failed: %s", err)
+	}
+	defer db.Close()
+
+	got, err := db.Unchanged(UpdateRating, matched)
+	if err != nil {
+		t.Fatalf("Unchanged() failed: %s", err)
+	}
+	if len(got) != 2 || !got[matched[0]] || !got[matched[1]] {
+		t.Errorf("Unchanged() ratings = %v, want the first 2 pairs", got)
+	}
+	if got, err := db.Unchanged(UpdatePlayCount, matched); err != nil || len(got) != 1 || !got[matched[0]] {
+		t.Errorf("Unchanged() play counts = %v, %v, want the first pair", got, err)
+	}
+
+	// A src change since the push, or another dst library, needs updating.
+	changed := NewPairing([]SongInfo{
+		testPlayedSong{testSong{"1", "/a/1.mp3", 5}, played.Add(time.Hour), 4},
+		testStarredSong{testSong{"2", "/a/2.mp3", 4}, false},
+	}, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}}).Matched()
+	for _, u := range []string{UpdatePlayCount, UpdatePlayTime} {
+		if got, err := db.Unchanged(u, changed); err != nil || len(got) != 0 {
+			t.Errorf("Unchanged(%s) after src change = %v, %v, want none", u, got, err)
+		}
+	}
+	if got, err := db.Unchanged(UpdateStar, changed); err != nil || got[changed[1]] {
+		t.Errorf("Unchanged(star) after src change = %v, %v, want the unstarred pair changed", got, err)
+	}
+	if got, err := db.Unchanged(UpdateRating, changed); err != nil || len(got) != 2 {
+		t.Errorf("Unchanged() ratings after other changes = %v, %v, want both", got, err)
+	}
+	other, err := OpenStateDb(path, "itunes:lib.xml", "subsonic:http://other.example.com")
+	if err != nil {
+		t.Fatalf("OpenStateDb() for other dst failed: %s", err)
+	}
+	defer other.Close()
+	if got, err := other.Unchanged(UpdateRating, matched); err != nil || len(got) != 0 {
+		t.Errorf("Unchanged() for other dst = %v, %v, want none", got, err)
+	}
+
+	runs, err := db.Runs()
+	if err != nil {
+		t.Fatalf("Runs() failed: %s", err)
+	}
+	if len(runs) != 1 || runs[0].Id != run || runs[0].Pushed != 2 || runs[0].Skipped != 1 || runs[0].Finished.IsZero() {
+		t.Errorf("Runs() = %+v", runs)
+	}
+}
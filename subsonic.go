@@ -0,0 +1,292 @@
+package itunes2subsonic
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/delucks/go-subsonic"
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// subsonicChild extends subsonic.Child with attributes that go-subsonic
+// doesn't know about but newer servers (e.g., Navidrome) report.
+type subsonicChild struct {
+	subsonic.Child
+	Played        time.Time `xml:"played,attr,omitempty"`
+	MusicBrainzID string    `xml:"musicBrainzId,attr,omitempty"`
+}
+
+type subsonicSong struct {
+	s *subsonicChild
+}
+
+func (s subsonicSong) Id() string          { return s.s.ID }
+func (s subsonicSong) Path() string        { return s.s.Path }
+func (s subsonicSong) FiveStarRating() int { return s.s.UserRating }
+
+func (s subsonicSong) Title() string           { return s.s.Title }
+func (s subsonicSong) Artist() string          { return s.s.Artist }
+func (s subsonicSong) AlbumArtist() string     { return "" }
+func (s subsonicSong) Album() string           { return s.s.Album }
+func (s subsonicSong) TrackNumber() int        { return s.s.Track }
+func (s subsonicSong) DiscNumber() int         { return s.s.DiscNumber }
+func (s subsonicSong) Duration() time.Duration { return time.Duration(s.s.Duration) * time.Second }
+func (s subsonicSong) Size() int64             { return s.s.Size }
+
+func (s subsonicSong) PlayCount() int        { return int(s.s.PlayCount) }
+func (s subsonicSong) LastPlayed() time.Time { return s.s.Played }
+
+func (s subsonicSong) Starred() bool { return !s.s.Starred.IsZero() }
+
+// DateModified is when the server added the song, as Subsonic doesn't report
+// modification. Replacing the file adds it again.
+func (s subsonicSong) DateModified() time.Time { return s.s.Created }
+
+func (s subsonicSong) MusicBrainzId() string { return s.s.MusicBrainzID }
+
+// subsonicAlbum extends subsonic.AlbumID3 with the user rating.
+type subsonicAlbum struct {
+	subsonic.AlbumID3
+	UserRating int `xml:"userRating,attr,omitempty"`
+}
+
+func (a *subsonicAlbum) Id() string          { return a.ID }
+func (a *subsonicAlbum) AlbumArtist() string { return a.Artist }
+func (a *subsonicAlbum) Name() string        { return a.AlbumID3.Name }
+func (a *subsonicAlbum) FiveStarRating() int { return a.UserRating }
+
+// SubsonicLibrary is a Source and Destination backed by a Subsonic API server.
+type SubsonicLibrary struct {
+	*subsonic.Client
+}
+
+// NewSubsonicLibrary wraps an authenticated client.
+func NewSubsonicLibrary(c *subsonic.Client) *SubsonicLibrary {
+	return &SubsonicLibrary{Client: c}
+}
+
+func (l *SubsonicLibrary) Name() string { return "subsonic:" + l.BaseUrl }
+
+func (l *SubsonicLibrary) Songs(bar *pb.ProgressBar) ([]SongInfo, error) {
+	return FetchSubsonicSongs(l.Client, bar)
+}
+
+// RecordPlay scrobbles the song as played at t, or now if t is zero.
+func (l *SubsonicLibrary) RecordPlay(id string, t time.Time) error {
+	params := map[string]string{}
+	if !t.IsZero() {
+		params["time"] = strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return l.Scrobble(id, params)
+}
+
+// SetStarred stars or unstars the song.
+func (l *SubsonicLibrary) SetStarred(id string, starred bool) error {
+	params := subsonic.StarParameters{SongIDs: []string{id}}
+	if starred {
+		return l.Star(params)
+	}
+	return l.Unstar(params)
+}
+
+// Albums pages through every album known to the server.
+func (l *SubsonicLibrary) Albums(bar *pb.ProgressBar) ([]AlbumInfo, error) {
+	var albums []AlbumInfo
+
+	offset := 0
+	for {
+		var list struct {
+			Album []*subsonicAlbum `xml:"albumList2>album"`
+		}
+		err := subsonicGet(l.Client, "getAlbumList2", url.Values{
+			"type":   {"alphabeticalByName"},
+			"size":   {"500"},
+			"offset": {strconv.Itoa(offset)},
+		}, &list)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching Subsonic albums: %w", err)
+		}
+
+		for _, a := range list.Album {
+			albums = append(albums, a)
+		}
+
+		if len(list.Album) == 0 {
+			break
+		}
+
+		offset += len(list.Album)
+		if bar != nil {
+			bar.Add(len(list.Album))
+		}
+	}
+
+	return albums, nil
+}
+
+// SetAlbumRating rates the album. The Subsonic API rates albums and songs the
+// same way.
+func (l *SubsonicLibrary) SetAlbumRating(id string, rating int) error {
+	return l.SetRating(id, rating)
+}
+
+// Playlists returns the playlists owned by the user.
+func (l *SubsonicLibrary) Playlists() ([]*PlaylistInfo, error) {
+	all, err := l.GetPlaylists(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching Subsonic playlists: %w", err)
+	}
+
+	var r []*PlaylistInfo
+	for _, v := range all {
+		if v.Owner != "" && v.Owner != l.User {
+			continue
+		}
+		full, err := l.GetPlaylist(v.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching Subsonic playlist '%s': %w", v.Name, err)
+		}
+		p := &PlaylistInfo{Id: v.ID, Name: v.Name, Comment: v.Comment, Public: v.Public, HasDetails: true}
+		for _, e := range full.Entry {
+			p.SongIds = append(p.SongIds, e.ID)
+		}
+		r = append(r, p)
+	}
+	return r, nil
+}
+
+// playlistChunk is the most song IDs sent in one request, as they're all in
+// the URL and servers limit its length.
+const playlistChunk = 100
+
+// WritePlaylist replaces the songs of the playlist with ID id, creating it if
+// id is empty, then sets the name, and the comment and public flag if p has
+// them. The songs are sent playlistChunk at a time.
+func (l *SubsonicLibrary) WritePlaylist(id string, p *PlaylistInfo) error {
+	first, rest := p.SongIds, []string(nil)
+	if len(first) > playlistChunk {
+		first, rest = first[:playlistChunk], first[playlistChunk:]
+	}
+	params := url.Values{"songId": first}
+	if id == "" {
+		params.Set("name", p.Name)
+	} else {
+		params.Set("playlistId", id)
+	}
+	// Servers implementing API 1.14.0 or later respond with the playlist.
+	var created struct {
+		Playlist struct {
+			ID string `xml:"id,attr"`
+		} `xml:"playlist"`
+	}
+	if err := subsonicGet(l.Client, "createPlaylist", params, &created); err != nil {
+		return err
+	}
+
+	if id == "" {
+		id = created.Playlist.ID
+	}
+	if id == "" {
+		all, err := l.GetPlaylists(nil)
+		if err != nil {
+			return err
+		}
+		for _, v := range all {
+			if v.Name == p.Name && (v.Owner == "" || v.Owner == l.User) {
+				id = v.ID
+			}
+		}
+		if id == "" {
+			return fmt.Errorf("created playlist '%s' not found", p.Name)
+		}
+	}
+
+	for len(rest) > 0 {
+		chunk := rest
+		if len(chunk) > playlistChunk {
+			chunk = chunk[:playlistChunk]
+		}
+		rest = rest[len(chunk):]
+		var added struct{}
+		if err := subsonicGet(l.Client, "updatePlaylist", url.Values{"playlistId": {id}, "songIdToAdd": chunk}, &added); err != nil {
+			return err
+		}
+	}
+
+	details := map[string]string{"name": p.Name}
+	if p.HasDetails {
+		details["comment"] = p.Comment
+		details["public"] = strconv.FormatBool(p.Public)
+	}
+	return l.UpdatePlaylist(id, details)
+}
+
+// subsonicGet issues a GET to the Subsonic API and decodes the response into
+// v. Used in place of the go-subsonic helpers when they would drop attributes
+// that are needed.
+func subsonicGet(c *subsonic.Client, endpoint string, params url.Values, v interface{}) error {
+	resp, err := c.Request("GET", endpoint, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var status struct {
+		Error *subsonic.Error `xml:"error"`
+	}
+	if err := xml.Unmarshal(body, &status); err != nil {
+		return err
+	}
+	if status.Error != nil {
+		return fmt.Errorf("Error #%d: %s", status.Error.Code, status.Error.Message)
+	}
+
+	return xml.Unmarshal(body, v)
+}
+
+// FetchSubsonicSongs pages through every song known to the server. bar, if
+// given, is advanced as songs arrive.
+func FetchSubsonicSongs(c *subsonic.Client, bar *pb.ProgressBar) ([]SongInfo, error) {
+	var tracks []SongInfo
+
+	offset := 0
+	for {
+		var songs struct {
+			Song []*subsonicChild `xml:"searchResult3>song"`
+		}
+		err := subsonicGet(c, "search3", url.Values{
+			"query":       {`""`},
+			"songCount":   {"400"},
+			"songOffset":  {strconv.Itoa(offset)},
+			"artistCount": {"0"},
+			"albumCount":  {"0"},
+		}, &songs)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching Subsonic songs: %w", err)
+		}
+
+		for _, s := range songs.Song {
+			tracks = append(tracks, subsonicSong{s})
+		}
+
+		if len(songs.Song) == 0 {
+			break
+		}
+
+		offset += len(songs.Song)
+		if bar != nil {
+			bar.Add(len(songs.Song))
+		}
+	}
+
+	return tracks, nil
+}
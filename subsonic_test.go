@@ -0,0 +1,128 @@
+package itunes2subsonic
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/delucks/go-subsonic"
+)
+
+func TestFetchSubsonicSongs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<subsonic-response xmlns="http://subsonic.org/restapi" status="ok" version="1.16.1">`)
+		if r.URL.Query().Get("songOffset") == "0" {
+			fmt.Fprint(w, `<searchResult3>
+<song id="s1" title="2112" path="Rush/2112/01-_2112_.mp3" userRating="4" playCount="3" played="2022-12-18T08:08:49.123Z" musicBrainzId="a3a1d0a2-7a5f-4fd5-9d8a-000000000001"></song>
+<song id="s2" title="Tears" path="Rush/2112/05-Tears.mp3"></song>
+</searchResult3>`)
+		} else {
+			fmt.Fprint(w, `<searchResult3></searchResult3>`)
+		}
+		fmt.Fprint(w, `</subsonic-response>`)
+	}))
+	defer ts.Close()
+
+	c := &subsonic.Client{Client: ts.Client(), BaseUrl: ts.URL, User: "test", ClientName: "test"}
+	songs, err := FetchSubsonicSongs(c, nil)
+	if err != nil {
+		t.Fatalf("FetchSubsonicSongs() failed: %s", err)
+	}
+	if len(songs) != 2 {
+		t.Fatalf("FetchSubsonicSongs() len = %d, want 2", len(songs))
+	}
+
+	s := songs[0]
+	if s.Id() != "s1" || s.Path() != "Rush/2112/01-_2112_.mp3" || s.FiveStarRating() != 4 {
+		t.Errorf("FetchSubsonicSongs()[0] = %s %s %d, want s1 Rush/2112/01-_2112_.mp3 4", s.Id(), s.Path(), s.FiveStarRating())
+	}
+	plays := s.(SongPlays)
+	if want := time.Date(2022, 12, 18, 8, 8, 49, 123000000, time.UTC); !plays.LastPlayed().Equal(want) || plays.PlayCount() != 3 {
+		t.Errorf("FetchSubsonicSongs()[0] played %s x%d, want %s x3", plays.LastPlayed(), plays.PlayCount(), want)
+	}
+	if id := musicBrainzId(s); id != "a3a1d0a2-7a5f-4fd5-9d8a-000000000001" {
+		t.Errorf("FetchSubsonicSongs()[0] MusicBrainz ID = %s", id)
+	}
+	if !songs[1].(SongPlays).LastPlayed().IsZero() {
+		t.Errorf("FetchSubsonicSongs()[1].LastPlayed() = %s, want zero", songs[1].(SongPlays).LastPlayed())
+	}
+}
+
+func TestFetchSubsonicSongsError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<subsonic-response xmlns="http://subsonic.org/restapi" status="failed" version="1.16.1"><error code="40" message="Wrong username or password"></error></subsonic-response>`)
+	}))
+	defer ts.Close()
+
+	c := &subsonic.Client{Client: ts.Client(), BaseUrl: ts.URL, User: "test", ClientName: "test"}
+	if _, err := FetchSubsonicSongs(c, nil); err == nil {
+		t.Errorf("FetchSubsonicSongs() succeeded, want error")
+	}
+}
+
+func TestSubsonicWritePlaylist(t *testing.T) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		for _, k := range []string{"f", "v", "c", "u", "t", "s"} {
+			q.Del(k)
+		}
+		calls = append(calls, r.URL.Path+"?"+q.Encode())
+		fmt.Fprint(w, `<subsonic-response xmlns="http://subsonic.org/restapi" status="ok" version="1.16.1">`)
+		if r.URL.Path == "/rest/createPlaylist" {
+			fmt.Fprint(w, `<playlist id="p9" name="Favs"></playlist>`)
+		}
+		fmt.Fprint(w, `</subsonic-response>`)
+	}))
+	defer ts.Close()
+
+	l := NewSubsonicLibrary(&subsonic.Client{Client: ts.Client(), BaseUrl: ts.URL, User: "test", ClientName: "test"})
+	p := &PlaylistInfo{Name: "Favs", SongIds: []string{"s2", "s1"}}
+	if err := l.WritePlaylist("", p); err != nil {
+		t.Fatalf("WritePlaylist() failed: %s", err)
+	}
+	// Only a playlist with details sets them.
+	p.Comment, p.HasDetails = "road", true
+	if err := l.WritePlaylist("p9", p); err != nil {
+		t.Fatalf("WritePlaylist() failed: %s", err)
+	}
+
+	want := []string{
+		"/rest/createPlaylist?name=Favs&songId=s2&songId=s1",
+		"/rest/updatePlaylist?name=Favs&playlistId=p9",
+		"/rest/createPlaylist?playlistId=p9&songId=s2&songId=s1",
+		"/rest/updatePlaylist?comment=road&name=Favs&playlistId=p9&public=false",
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("WritePlaylist() calls = %q, want %q", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("WritePlaylist() call %d = %s, want %s", i, calls[i], want[i])
+		}
+	}
+
+	// A long playlist is sent in chunks so the URLs stay short.
+	calls = nil
+	long := &PlaylistInfo{Name: "Everything"}
+	for i := 0; i < 2*playlistChunk+1; i++ {
+		long.SongIds = append(long.SongIds, fmt.Sprintf("s%d", i))
+	}
+	if err := l.WritePlaylist("p9", long); err != nil {
+		t.Fatalf("WritePlaylist() of long playlist failed: %s", err)
+	}
+	if len(calls) != 4 {
+		t.Fatalf("WritePlaylist() of long playlist calls = %q, want 4", calls)
+	}
+	for i, c := range calls[:3] {
+		if n := strings.Count(c, "songId"); n > playlistChunk || n == 0 {
+			t.Errorf("WritePlaylist() call %d has %d songs, want 1 to %d", i, n, playlistChunk)
+		}
+	}
+	if !strings.HasPrefix(calls[1], "/rest/updatePlaylist?playlistId=p9&songIdToAdd=s100&") || !strings.HasSuffix(calls[2], "songIdToAdd=s200") {
+		t.Errorf("WritePlaylist() of long playlist calls = %q", calls)
+	}
+}
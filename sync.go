@@ -0,0 +1,675 @@
+package itunes2subsonic
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	pb "github.com/schollz/progressbar/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+// SyncOptions configures Sync.
+type SyncOptions struct {
+	DryRun      bool
+	CopyUnrated bool
+	SkipCount   int
+	// CopyLoved stars the songs loved in src. With CopyUnloved, songs not
+	// loved in src are unstarred.
+	CopyLoved, CopyUnloved bool
+	// UpdatePlayed records a play on dst when src was played more recently.
+	UpdatePlayed bool
+	// UpdatePlayCount records the plays dst is missing so its play count
+	// matches src.
+	UpdatePlayCount bool
+	// CopyAlbumRatings copies the album ratings when both src and dst rate
+	// albums.
+	CopyAlbumRatings bool
+	// Playlists are the names of the src playlists to copy, separated by
+	// commas. With AllPlaylists, every src playlist is copied.
+	Playlists    string
+	AllPlaylists bool
+
+	// MatchMetadata pairs the songs left unmatched by path when their
+	// metadata scores at least MatchThreshold.
+	MatchMetadata  bool
+	MatchThreshold float64
+	// MatchSuffixes are regular expressions stripped from titles, artists
+	// and albums before matching, in addition to DefaultSuffixPatterns.
+	MatchSuffixes stringList
+
+	// SrcRoot and DstRoot are the library prefixes. RootMaps are more of
+	// them, for libraries spanning several folders. Detected if all are
+	// empty.
+	SrcRoot, DstRoot string
+	RootMaps         rootList
+	// Rewrites are applied, in order, to the paths before pairing.
+	Rewrites rewriteList
+	// PairById pairs the songs with the same ID before their paths, for src
+	// and dst that are the same library.
+	PairById bool
+	// TwoWay copies ratings in both directions, whichever side changed since
+	// the ratings saved in StateDb. Ratings changed on both sides are
+	// resolved by Conflicts, e.g., ConflictSrc. Input answers the prompts of
+	// ConflictInteractive, os.Stdin if nil.
+	TwoWay    bool
+	Conflicts string
+	Input     io.Reader
+	// StateDb is an SQLite database recording each run, the pairs it saw
+	// and pushed, and a journal of the ratings and stars it changed. With
+	// Incremental, only the pairs whose src values changed since they were
+	// last pushed are updated, so changes made in dst since are kept.
+	StateDb     string
+	Incremental bool
+	// BackupDir, unless empty, gets a Snapshot of dst, as fetched, before
+	// it's modified, and of src too with TwoWay. BackupFormat is the format
+	// of the snapshot, e.g., SnapshotJson.
+	BackupDir    string
+	BackupFormat string
+	// Collisions is how to choose between songs in one library with the
+	// same path, e.g., CollisionRating.
+	Collisions string
+	// Report is the format of the report, e.g., ReportJson for a record of
+	// every pair. Other than ReportText, the records are written to
+	// ReportFile, or Records (os.Stdout if nil) with the text report then
+	// written elsewhere, as chosen by TextOutput.
+	Report     string
+	ReportFile string
+	Records    io.Writer
+	// ExplainPath reports how the songs with this ID, or a path containing
+	// it, were paired.
+	ExplainPath string
+	// RootFlags names the flags that set SrcRoot and DstRoot, e.g.,
+	// "--itunes_root and --subsonic_root".
+	RootFlags string
+}
+
+// RegisterFlags registers the options shared by every command on fs.
+func (o *SyncOptions) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.DryRun, "dry_run", true, "don't modify the library")
+	fs.IntVar(&o.SkipCount, "skip_count", 10, "a limit on the number of tracks that would be skipped before refusing to process")
+	fs.BoolVar(&o.CopyUnrated, "copy_unrated", false, "if true, will unset rating if src is unrated")
+	fs.BoolVar(&o.CopyLoved, "copy_loved", false, "star songs that are loved in src")
+	fs.BoolVar(&o.CopyUnloved, "copy_unloved", false, "if true, will unstar songs that are not loved in src")
+	fs.BoolVar(&o.UpdatePlayed, "update_played", false, "update the Last Played time. Each update is a scrobble, so it also adds one to the server's play count")
+	fs.BoolVar(&o.UpdatePlayCount, "update_play_count", false, "record the missing plays so the play count matches src")
+	fs.BoolVar(&o.CopyAlbumRatings, "copy_album_ratings", false, "copy the album ratings set in src")
+	fs.BoolVar(&o.MatchMetadata, "match_metadata", false, "pair the songs not found by path using their title, artist, album, track number, duration and size")
+	fs.Float64Var(&o.MatchThreshold, "match_threshold", 0.8, "the minimum confidence, from 0 to 1, for --match_metadata")
+	fs.Var(&o.MatchSuffixes, "match_suffix", "a regular expression to strip from titles, artists and albums for --match_metadata, e.g., '\\s*\\(live\\)'. Repeatable")
+	fs.StringVar(&o.Playlists, "playlists", "", "comma-separated names of src playlists to create or update in dst")
+	fs.BoolVar(&o.AllPlaylists, "all_playlists", false, "create or update every src playlist in dst")
+	fs.Var(&o.RootMaps, "map", "(optional) a src library prefix and the dst prefix of the same folder, e.g., 'file://localhost/D:/Podcasts/=/podcasts/'. Repeatable")
+	fs.Var(&o.Rewrites, "rewrite", "(optional) a regular expression substitution applied to paths before pairing, as '[src:|dst:]pattern=>replacement', e.g., 'src:(\\d+) - (.*)\\.mp3$=>${1}-_${2}_.mp3'. Repeatable, applied in order")
+	fs.BoolVar(&o.TwoWay, "two_way", false, "copy ratings both ways, whichever side changed since the last run with --two_way, as recorded in --state_db. Needs a src that can set ratings")
+	fs.StringVar(&o.Conflicts, "conflicts", ConflictSrc, "for --two_way, which rating to keep if both changed: 'src', 'dst', 'higher' or 'interactive' to ask")
+	fs.StringVar(&o.StateDb, "state_db", "i2s.db", "an SQLite database recording each run that isn't a dry run, the songs it saw and updated and the ratings and stars it changed, created if needed. Empty to keep no record")
+	fs.BoolVar(&o.Incremental, "incremental", false, "only update the songs changed in src since they were last updated, as recorded in --state_db")
+	fs.StringVar(&o.BackupDir, "backup_dir", "i2s_backups", "where to save a snapshot of the ratings, stars and plays of --to before modifying it, restored with i2s restore. Empty to save none")
+	fs.StringVar(&o.BackupFormat, "backup_format", SnapshotJson, "the format of the --backup_dir snapshots: 'json' or 'csv'")
+	fs.StringVar(&o.Collisions, "collisions", CollisionRating, "how to choose between songs in one library with the same path: 'rating' keeps the highest rated, 'modified' the most recently modified and 'skip' none")
+	fs.StringVar(&o.Report, "report", ReportText, "the report format: 'text', or 'json' or 'csv' for a record of every song with its IDs, paths, ratings, match strategy and the updates made")
+	fs.StringVar(&o.ReportFile, "report_file", "", "(optional) where to write a --report other than text, otherwise written to stdout with the text report on stderr")
+	fs.StringVar(&o.ExplainPath, "explain_path", "", "(optional) report each step taken to pair the songs with this ID or a path containing it")
+}
+
+// TextOutput returns where the text report, and any output following it,
+// goes so it isn't mixed with the records: os.Stdout, unless the records are
+// written there, then os.Stderr.
+func (o *SyncOptions) TextOutput() io.Writer {
+	if o.Report != ReportText && o.ReportFile == "" && o.Records == nil {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// rootList is a repeatable flag of root mappings written as "src=dst".
+type rootList []RootMapping
+
+func (l *rootList) String() string {
+	var s []string
+	for _, m := range *l {
+		s = append(s, m.SrcRoot+"="+m.DstRoot)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (l *rootList) Set(s string) error {
+	m, err := ParseRootMapping(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, m)
+	return nil
+}
+
+// rewriteList is a repeatable flag of path rewrite rules.
+type rewriteList []RewriteRule
+
+func (l *rewriteList) String() string {
+	var s []string
+	for _, r := range *l {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+func (l *rewriteList) Set(s string) error {
+	r, err := ParseRewriteRule(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// FetchLibraries fetches the songs of src and dst concurrently.
+func FetchLibraries(src, dst Source) ([]SongInfo, []SongInfo, error) {
+	var srcSongs, dstSongs []SongInfo
+	var g errgroup.Group
+	fetchBar := PbWithOptions(pb.Default(-1, "fetching library data"))
+	g.Go(func() error {
+		var err error
+		srcSongs, err = src.Songs(fetchBar)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		dstSongs, err = dst.Songs(fetchBar)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	fetchBar.Finish()
+
+	return srcSongs, dstSongs, nil
+}
+
+// FetchAlbums fetches the albums of src and dst concurrently.
+func FetchAlbums(src, dst AlbumLibrary) ([]AlbumInfo, []AlbumInfo, error) {
+	var srcAlbums, dstAlbums []AlbumInfo
+	var g errgroup.Group
+	fetchBar := PbWithOptions(pb.Default(-1, "fetching album data"))
+	g.Go(func() error {
+		var err error
+		srcAlbums, err = src.Albums(fetchBar)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		dstAlbums, err = dst.Albums(fetchBar)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	fetchBar.Finish()
+
+	return srcAlbums, dstAlbums, nil
+}
+
+// syncStep is one kind of update made by Sync, e.g., copying ratings.
+type syncStep struct {
+	// what is plural for the report, e.g., "Ratings".
+	what  string
+	count int
+	// updates are those of the records made by run, e.g., UpdateRating.
+	updates []string
+	run     func(skip *SkipCounter) error
+}
+
+// Sync pairs the songs of src and dst, reports the differences to w and,
+// unless DryRun is set, copies the ratings from src to dst. The pairing is
+// returned for any follow-up work by the caller, whose output should go to w
+// too, e.g., opts.TextOutput().
+func Sync(w io.Writer, src Source, dst Destination, opts SyncOptions) (_ *Pairing, err error) {
+	if !ValidCollisionPolicy(opts.Collisions) {
+		return nil, fmt.Errorf("unknown --collisions policy '%s'", opts.Collisions)
+	}
+	if !ValidConflictPolicy(opts.Conflicts) {
+		return nil, fmt.Errorf("unknown --conflicts policy '%s'", opts.Conflicts)
+	}
+	if !ValidSnapshotFormat(opts.BackupFormat) {
+		return nil, fmt.Errorf("unknown --backup_format '%s'", opts.BackupFormat)
+	}
+	if !ValidReportFormat(opts.Report) {
+		return nil, fmt.Errorf("unknown --report format '%s'", opts.Report)
+	}
+	records := opts.Records
+	if records == nil {
+		records = os.Stdout
+	}
+	srcRater, srcCanRate := src.(RatingSetter)
+	if opts.TwoWay && !srcCanRate {
+		return nil, fmt.Errorf("--two_way needs a src that can set ratings, %s can't", src.Name())
+	}
+	if opts.Incremental && opts.StateDb == "" {
+		return nil, errors.New("--incremental needs --state_db")
+	}
+	if opts.TwoWay && opts.StateDb == "" {
+		return nil, errors.New("--two_way needs --state_db")
+	}
+	// Dry runs aren't recorded. They only read the database, if there's one
+	// and they need what it records.
+	var db *StateDb
+	if opts.StateDb != "" {
+		var err error
+		if !opts.DryRun {
+			db, err = OpenStateDb(opts.StateDb, src.Name(), dst.Name())
+		} else if _, serr := os.Stat(opts.StateDb); serr == nil && (opts.Incremental || opts.TwoWay) {
+			db, err = OpenStateDbReadOnly(opts.StateDb, src.Name(), dst.Name())
+		}
+		if err != nil {
+			return nil, err
+		}
+		if db != nil {
+			defer db.Close()
+		}
+	}
+
+	srcSongs, dstSongs, err := FetchLibraries(src, dst)
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching library info: %w", err)
+	}
+	fmt.Fprintf(w, "Src track count %d, Dst track count %d\n", len(srcSongs), len(dstSongs))
+
+	roots := []RootMapping(opts.RootMaps)
+	if opts.SrcRoot != "" || opts.DstRoot != "" {
+		roots = append([]RootMapping{{opts.SrcRoot, opts.DstRoot}}, roots...)
+	}
+	pairing := NewPairing(srcSongs, dstSongs, PairingOptions{Roots: roots, Rewrites: opts.Rewrites, Collisions: opts.Collisions, ById: opts.PairById})
+	if len(pairing.Roots) == 0 {
+		fmt.Fprintln(w, "Music library root: none found, pairing by full path")
+	}
+	for i, m := range pairing.Roots {
+		fmt.Fprintf(w, "Music library root: %s\n", m)
+		if i < len(pairing.RootGuesses) {
+			g := pairing.RootGuesses[i]
+			fmt.Fprintf(w, "Detected from %d of %d songs with matching file names (confidence %.0f%%)\n", g.Votes, g.Samples, 100*g.Confidence())
+		}
+	}
+	if opts.MatchMetadata {
+		n, err := NewTextNormalizer(append(DefaultSuffixPatterns[:len(DefaultSuffixPatterns):len(DefaultSuffixPatterns)], opts.MatchSuffixes...))
+		if err != nil {
+			return nil, err
+		}
+		pairing.MatchByMetadata(opts.MatchThreshold, n)
+	}
+	PrintMatchStrategies(w, pairing)
+	PrintRootStats(w, pairing)
+	PrintCollisions(w, pairing)
+	if opts.ExplainPath != "" {
+		PrintExplainPath(w, pairing, opts.ExplainPath)
+	}
+
+	PrintMissing(w, pairing, opts.RootFlags)
+
+	var run int64
+	// unchanged are the pairs to skip for each update, e.g., UpdateRating.
+	unchanged := make(map[string]map[*SongPair]bool)
+	if db != nil && opts.Incremental {
+		for _, u := range []string{UpdateRating, UpdateStar, UpdatePlayCount, UpdatePlayTime} {
+			if unchanged[u], err = db.Unchanged(u, pairing.Matched()); err != nil {
+				return nil, fmt.Errorf("failed to read --state_db: %w", err)
+			}
+		}
+		fmt.Fprintf(w, "Note: --incremental skips the songs unchanged in src since they were last updated: ratings(%d) stars(%d) play counts(%d) play times(%d)\n",
+			len(unchanged[UpdateRating]), len(unchanged[UpdateStar]), len(unchanged[UpdatePlayCount]), len(unchanged[UpdatePlayTime]))
+	}
+	recording := db != nil && !opts.DryRun
+	if recording {
+		if run, err = db.BeginRun(); err != nil {
+			return nil, fmt.Errorf("failed to record run: %w", err)
+		}
+		if err := db.RecordSeen(run, pairing.Matched()); err != nil {
+			return nil, fmt.Errorf("failed to record run: %w", err)
+		}
+	}
+
+	// The ratings and stars set are journaled so the run can be undone, as
+	// are the album ratings below.
+	var rater RatingSetter = dst
+	if recording {
+		rater = db.JournalRatings(run, dst.Name(), false, dst, dstSongs)
+		if opts.TwoWay {
+			srcRater = db.JournalRatings(run, src.Name(), true, srcRater, srcSongs)
+		}
+	}
+
+	// The updates planned for each pair, for the records.
+	planned := make(map[*SongPair][]string)
+	plan := func(what string, pairs []*SongPair) {
+		for _, v := range pairs {
+			planned[v] = append(planned[v], what)
+		}
+	}
+	conflicts := make(map[*SongPair]bool)
+	// The records are written however the run ends as, when a step fails,
+	// they tell which updates were made. skip is nil until the steps run.
+	var skip *SkipCounter
+	unfinished := make(map[string]bool)
+	defer func() {
+		if werr := writeRecords(records, opts, NewReportRecords(pairing, planned, conflicts, skip, unfinished)); err == nil {
+			err = werr
+		}
+	}()
+
+	var steps []syncStep
+	var state *RatingState
+	if opts.TwoWay {
+		// A dry run before the first two-way run has nothing recorded.
+		state = &RatingState{Ratings: make(map[string]int)}
+		if db != nil {
+			if state, err = db.RatingState(); err != nil {
+				return nil, fmt.Errorf("failed to read --state_db: %w", err)
+			}
+		}
+		// Only ask if the answers will be used.
+		var prompt ConflictPrompt
+		if !opts.DryRun {
+			in := opts.Input
+			if in == nil {
+				in = os.Stdin
+			}
+			prompt = NewConflictPrompt(w, in)
+		}
+		changes := PlanTwoWayRatings(pairing, state, opts.Conflicts, opts.CopyUnrated, prompt)
+		PrintTwoWayRatings(w, changes)
+		resolved := 0
+		for _, c := range changes {
+			switch {
+			case c.Unresolved:
+				conflicts[c.Pair] = true
+			case c.ToSrc:
+				plan(UpdateSrcRating, []*SongPair{c.Pair})
+				resolved++
+			default:
+				plan(UpdateRating, []*SongPair{c.Pair})
+				resolved++
+			}
+		}
+		steps = append(steps, syncStep{"Two-Way Ratings", resolved, []string{UpdateRating, UpdateSrcRating}, func(skip *SkipCounter) error {
+			return CopyRatingChanges(srcRater, rater, changes, state, skip)
+		}})
+	} else {
+		mismatched := changedOnly(pairing.MismatchedRatings(opts.CopyUnrated), unchanged[UpdateRating])
+		PrintMismatchedRatings(w, mismatched)
+		plan(UpdateRating, mismatched)
+		steps = append(steps, syncStep{"Ratings", len(mismatched), []string{UpdateRating}, func(skip *SkipCounter) error {
+			return CopyRatings(rater, mismatched, skip)
+		}})
+	}
+
+	if opts.CopyAlbumRatings {
+		srcAlbums, srcOk := src.(AlbumLibrary)
+		dstAlbums, dstOk := dst.(AlbumLibrary)
+		albumRater, albumRaterOk := dst.(AlbumRatingSetter)
+		if srcOk && dstOk && albumRaterOk {
+			srcList, dstList, err := FetchAlbums(srcAlbums, dstAlbums)
+			if err != nil {
+				return nil, fmt.Errorf("failed while fetching album info: %w", err)
+			}
+			if recording {
+				albumRater = db.JournalAlbumRatings(run, dst.Name(), false, albumRater, dstList)
+			}
+			albums := PairAlbums(srcList, dstList)
+			albumRatings := MismatchedAlbumRatings(albums, opts.CopyUnrated)
+			PrintMismatchedAlbumRatings(w, MissingRatedAlbums(albums), albumRatings)
+			steps = append(steps, syncStep{"Album Ratings", len(albumRatings), nil, func(skip *SkipCounter) error {
+				return CopyAlbumRatings(albumRater, albumRatings, skip)
+			}})
+		} else {
+			fmt.Fprintf(w, "Note: album ratings are not supported from %s to %s\n", src.Name(), dst.Name())
+		}
+	}
+
+	if opts.CopyLoved {
+		if starrer, ok := dst.(Starrer); ok {
+			if recording {
+				starrer = db.JournalStars(run, dst.Name(), false, starrer, dstSongs)
+			}
+			stars := changedOnly(pairing.MismatchedStars(opts.CopyUnloved), unchanged[UpdateStar])
+			PrintMismatchedStars(w, stars)
+			plan(UpdateStar, stars)
+			steps = append(steps, syncStep{"Stars", len(stars), []string{UpdateStar}, func(skip *SkipCounter) error {
+				return CopyStars(starrer, stars, skip)
+			}})
+		} else {
+			fmt.Fprintf(w, "Note: %s does not support stars\n", dst.Name())
+		}
+	}
+
+	recorder, canRecord := dst.(PlayRecorder)
+	if (opts.UpdatePlayed || opts.UpdatePlayCount) && !canRecord {
+		fmt.Fprintf(w, "Note: %s does not support updating plays\n", dst.Name())
+	}
+	var missingPlays []*SongPair
+	if opts.UpdatePlayCount && canRecord {
+		missingPlays = changedOnly(pairing.MissingPlayCounts(), unchanged[UpdatePlayCount])
+		PrintMissingPlayCounts(w, missingPlays)
+		plan(UpdatePlayCount, missingPlays)
+		steps = append(steps, syncStep{"Play Counts", len(missingPlays), []string{UpdatePlayCount}, func(skip *SkipCounter) error {
+			return CopyPlayCounts(recorder, missingPlays, skip)
+		}})
+	}
+	if opts.UpdatePlayed && canRecord {
+		// Copying the play count also sets the play time so skip those songs.
+		counted := make(map[*SongPair]bool)
+		for _, v := range missingPlays {
+			counted[v] = true
+		}
+		var stalePlays []*SongPair
+		for _, v := range changedOnly(pairing.StalePlayDates(), unchanged[UpdatePlayTime]) {
+			if !counted[v] {
+				stalePlays = append(stalePlays, v)
+			}
+		}
+		PrintStalePlayDates(w, stalePlays)
+		plan(UpdatePlayTime, stalePlays)
+		steps = append(steps, syncStep{"Play Times", len(stalePlays), []string{UpdatePlayTime}, func(skip *SkipCounter) error {
+			return CopyPlayDates(recorder, stalePlays, skip)
+		}})
+	}
+
+	if opts.AllPlaylists || opts.Playlists != "" {
+		srcPlaylists, srcOk := src.(PlaylistSource)
+		dstPlaylists, dstOk := dst.(PlaylistWriter)
+		if srcOk && dstOk {
+			updates, err := planPlaylists(w, srcPlaylists, dstPlaylists, pairing, opts)
+			if err != nil {
+				return nil, err
+			}
+			PrintPlaylistUpdates(w, updates)
+			var changed []*PlaylistUpdate
+			for _, u := range updates {
+				if u.Changed() {
+					changed = append(changed, u)
+				}
+			}
+			steps = append(steps, syncStep{"Playlists", len(changed), nil, func(skip *SkipCounter) error {
+				return CopyPlaylists(dstPlaylists, changed, skip)
+			}})
+		} else {
+			fmt.Fprintf(w, "Note: playlists are not supported from %s to %s\n", src.Name(), dst.Name())
+		}
+	}
+
+	for _, s := range steps {
+		fmt.Fprintf(w, "== Copy %d %s To %s ==\n", s.count, s.what, dst.Name())
+	}
+	if opts.DryRun {
+		fmt.Fprintf(w, "Set --dry_run=false to modify %s\n", dst.Name())
+		return pairing, nil
+	}
+
+	skip = &SkipCounter{Limit: opts.SkipCount}
+	for _, s := range steps {
+		for _, u := range s.updates {
+			unfinished[u] = true
+		}
+	}
+
+	// Pause to give the user a chance to quit.
+	time.Sleep(400 * time.Millisecond)
+
+	if opts.BackupDir != "" {
+		backups := []*Snapshot{NewSnapshot(dst.Name(), dstSongs)}
+		if opts.TwoWay {
+			backups = append(backups, NewSnapshot(src.Name(), srcSongs))
+		}
+		for _, b := range backups {
+			path, err := b.Save(opts.BackupDir, opts.BackupFormat)
+			if err != nil {
+				return pairing, fmt.Errorf("failed to back up %s: %w", b.Library, err)
+			}
+			fmt.Fprintf(w, "Note: backed up %s to %s\n", b.Library, path)
+		}
+	}
+
+	pushed := 0
+	for _, s := range steps {
+		if s.count > 0 {
+			if err := s.run(skip); err != nil {
+				return pairing, err
+			}
+			pushed += s.count
+		}
+		for _, u := range s.updates {
+			delete(unfinished, u)
+		}
+	}
+	if recording {
+		if err := db.RecordPushed(run, pushedUpdates(pairing.Matched(), planned, skip)); err != nil {
+			return pairing, fmt.Errorf("failed to record run: %w", err)
+		}
+		if err := db.FinishRun(run, pushed-skip.Count(), skip.Count()); err != nil {
+			return pairing, fmt.Errorf("failed to record run: %w", err)
+		}
+		fmt.Fprintf(w, "Note: recorded as run %d in %s, ratings, album ratings and stars can be undone\n", run, opts.StateDb)
+	}
+	if state != nil {
+		if err := db.SaveRatingState(state); err != nil {
+			return pairing, fmt.Errorf("failed to record run: %w", err)
+		}
+	}
+	return pairing, nil
+}
+
+// writeRecords writes records to opts.ReportFile, or w if not set, unless
+// opts.Report is ReportText.
+func writeRecords(w io.Writer, opts SyncOptions, records []ReportRecord) error {
+	if opts.Report == ReportText {
+		return nil
+	}
+	if opts.ReportFile == "" {
+		return WriteReportRecords(w, opts.Report, records)
+	}
+	f, err := os.Create(opts.ReportFile)
+	if err != nil {
+		return fmt.Errorf("failed to write --report_file: %w", err)
+	}
+	if err := WriteReportRecords(f, opts.Report, records); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write --report_file: %w", err)
+	}
+	return f.Close()
+}
+
+// skipWhat is what the Copy functions report to SkipCounter for each update.
+var skipWhat = map[string]string{
+	UpdateRating:    "rating",
+	UpdateStar:      "star",
+	UpdatePlayCount: "play count",
+	UpdatePlayTime:  "play time",
+}
+
+// pushedUpdates returns the updates, e.g., UpdateRating, that leave dst in line
+// with src for each of pairs: those planned that didn't fail and those dst
+// was already in line with. Updates that failed, or were never run, are left
+// out so the next --incremental run tries them again.
+func pushedUpdates(pairs []*SongPair, planned map[*SongPair][]string, skip *SkipCounter) map[*SongPair][]string {
+	pushed := make(map[*SongPair][]string)
+	for _, v := range pairs {
+		done := make(map[string]bool)
+		for _, u := range planned[v] {
+			done[u] = true
+		}
+		srcStar, srcOk := starred(v.Src)
+		dstStar, dstOk := starred(v.Dst)
+		inLine := map[string]bool{
+			UpdateRating:    v.Src.FiveStarRating() == v.Dst.FiveStarRating(),
+			UpdateStar:      srcOk && dstOk && srcStar == dstStar,
+			UpdatePlayCount: playCount(v.Dst) >= playCount(v.Src),
+			UpdatePlayTime:  !lastPlayed(v.Dst).Before(lastPlayed(v.Src)),
+		}
+		for _, u := range []string{UpdateRating, UpdateStar, UpdatePlayCount, UpdatePlayTime} {
+			if (done[u] || inLine[u]) && !skip.SkippedWhat(v.Key, skipWhat[u]) {
+				pushed[v] = append(pushed[v], u)
+			}
+		}
+	}
+	return pushed
+}
+
+// changedOnly returns the pairs not in unchanged.
+func changedOnly(pairs []*SongPair, unchanged map[*SongPair]bool) []*SongPair {
+	if len(unchanged) == 0 {
+		return pairs
+	}
+	var r []*SongPair
+	for _, v := range pairs {
+		if !unchanged[v] {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+// planPlaylists fetches and plans the playlists selected by opts.
+func planPlaylists(w io.Writer, src PlaylistSource, dst PlaylistWriter, pairing *Pairing, opts SyncOptions) ([]*PlaylistUpdate, error) {
+	srcList, err := src.Playlists()
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching playlists: %w", err)
+	}
+	dstList, err := dst.Playlists()
+	if err != nil {
+		return nil, fmt.Errorf("failed while fetching playlists: %w", err)
+	}
+
+	if !opts.AllPlaylists {
+		var notFound []string
+		srcList, notFound = SelectPlaylists(srcList, strings.Split(opts.Playlists, ","))
+		for _, n := range notFound {
+			fmt.Fprintf(w, "Note: playlist '%s' not found\n", n)
+		}
+	}
+
+	// Playlists are matched by name so a repeated name can't be kept apart.
+	seen := make(map[string]bool)
+	var unique []*PlaylistInfo
+	for _, v := range srcList {
+		if seen[v.Name] {
+			fmt.Fprintf(w, "Note: skipping repeated playlist name '%s'\n", v.Name)
+			continue
+		}
+		seen[v.Name] = true
+		unique = append(unique, v)
+	}
+
+	return PlanPlaylists(unique, dstList, pairing), nil
+}
@@ -0,0 +1,166 @@
+package itunes2subsonic
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// testLibrary is a Destination keeping its songs and album ratings in memory.
+// Setting the songs in fail returns an error.
+type testLibrary struct {
+	name    string
+	ratings map[string]int
+	stars   map[string]bool
+	albums  map[string]int
+	fail    map[string]bool
+}
+
+// testAlbum is an album of testLibrary, named after its ID.
+type testAlbum struct {
+	id     string
+	rating int
+}
+
+func (a testAlbum) Id() string          { return a.id }
+func (a testAlbum) AlbumArtist() string { return "Rush" }
+func (a testAlbum) Name() string        { return a.id }
+func (a testAlbum) FiveStarRating() int { return a.rating }
+
+func (l *testLibrary) Name() string { return l.name }
+
+func (l *testLibrary) Songs(bar *pb.ProgressBar) ([]SongInfo, error) {
+	var songs []SongInfo
+	for id, r := range l.ratings {
+		songs = append(songs, testStarredSong{testSong{id, "/b/" + id + ".mp3", r}, l.stars[id]})
+	}
+	return songs, nil
+}
+
+func (l *testLibrary) SetRating(id string, rating int) error {
+	if l.fail[id] {
+		return errors.New("not found")
+	}
+	l.ratings[id] = rating
+	return nil
+}
+
+func (l *testLibrary) Albums(bar *pb.ProgressBar) ([]AlbumInfo, error) {
+	var albums []AlbumInfo
+	for id, r := range l.albums {
+		albums = append(albums, testAlbum{id, r})
+	}
+	return albums, nil
+}
+
+func (l *testLibrary) SetAlbumRating(id string, rating int) error {
+	l.albums[id] = rating
+	return nil
+}
+
+func (l *testLibrary) SetStarred(id string, starred bool) error {
+	if l.fail[id] {
+		return errors.New("not found")
+	}
+	l.stars[id] = starred
+	return nil
+}
+
+func TestSyncIncremental(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &testLibrary{name: "src", ratings: map[string]int{"1": 5, "2": 4}, stars: map[string]bool{"1": true}}
+	dst := &testLibrary{name: "dst", ratings: map[string]int{"1": 0, "2": 0}, stars: map[string]bool{}}
+	opts := SyncOptions{
+		SkipCount:    10,
+		Collisions:   CollisionRating,
+		Conflicts:    ConflictSrc,
+		BackupFormat: SnapshotJson,
+		Report:       ReportText,
+		StateDb:      filepath.Join(dir, "state.db"),
+		Incremental:  true,
+	}
+
+	// Dry runs don't create the database.
+	opts.DryRun = true
+	if _, err := Sync(ioutil.Discard, src, dst, opts); err != nil {
+		t.Fatalf("Sync() dry run failed: %s", err)
+	}
+	if _, err := os.Stat(opts.StateDb); !os.IsNotExist(err) {
+		t.Fatalf("Sync() dry run left %s: %v", opts.StateDb, err)
+	}
+	opts.DryRun = false
+
+	// Stars aren't copied at first.
+	if _, err := Sync(ioutil.Discard, src, dst, opts); err != nil {
+		t.Fatalf("Sync() failed: %s", err)
+	}
+	if dst.ratings["1"] != 5 || dst.ratings["2"] != 4 || dst.stars["1"] {
+		t.Fatalf("Sync() left dst %v %v", dst.ratings, dst.stars)
+	}
+
+	// A rating changed in dst since is kept, but copying stars now is as
+	// if they were never copied.
+	dst.ratings["2"] = 1
+	opts.CopyLoved = true
+	if _, err := Sync(ioutil.Discard, src, dst, opts); err != nil {
+		t.Fatalf("Sync() again failed: %s", err)
+	}
+	if dst.ratings["2"] != 1 || !dst.stars["1"] {
+		t.Errorf("Sync() with --copy_loved left dst %v %v, want rating 1 kept and song 1 starred", dst.ratings, dst.stars)
+	}
+}
+
+func TestSyncReportOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &testLibrary{name: "src", ratings: map[string]int{"1": 5, "2": 4, "3": 0}, stars: map[string]bool{"3": true}}
+	dst := &testLibrary{name: "dst", ratings: map[string]int{"1": 0, "2": 0, "3": 0}, stars: map[string]bool{}, fail: map[string]bool{"1": true, "2": true}}
+	opts := SyncOptions{
+		SkipCount:    1,
+		CopyLoved:    true,
+		Collisions:   CollisionRating,
+		Conflicts:    ConflictSrc,
+		BackupFormat: SnapshotJson,
+		Report:       ReportJson,
+		ReportFile:   filepath.Join(dir, "report.json"),
+	}
+
+	// The ratings give up before the stars are copied.
+	if _, err := Sync(ioutil.Discard, src, dst, opts); !errors.Is(err, ErrTooManySkipped) {
+		t.Fatalf("Sync() = %v, want %v", err, ErrTooManySkipped)
+	}
+	b, err := ioutil.ReadFile(opts.ReportFile)
+	if err != nil {
+		t.Fatalf("Sync() wrote no --report_file: %s", err)
+	}
+	var records []ReportRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		t.Fatalf("Sync() wrote %s: %s", b, err)
+	}
+	got := make(map[string]string)
+	for _, r := range records {
+		got[r.SrcId] = r.Action
+		if r.Action == ActionFailed && r.Error != "rating: not found" {
+			t.Errorf("record %s error = %q", r.SrcId, r.Error)
+		}
+	}
+	want := map[string]string{"1": ActionFailed, "2": ActionFailed, "3": ActionUnfinished}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sync() records = %v, want %v", got, want)
+	}
+}
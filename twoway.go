@@ -0,0 +1,229 @@
+package itunes2subsonic
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	pb "github.com/schollz/progressbar/v3"
+)
+
+// The policies for a rating changed in both libraries since the last two-way
+// sync, as set in SyncOptions.Conflicts.
+const (
+	// ConflictSrc keeps the src rating.
+	ConflictSrc = "src"
+	// ConflictDst keeps the dst rating.
+	ConflictDst = "dst"
+	// ConflictHigher keeps the higher rating.
+	ConflictHigher = "higher"
+	// ConflictInteractive asks which to keep. Without an answer, neither
+	// changes.
+	ConflictInteractive = "interactive"
+)
+
+// ValidConflictPolicy returns whether policy is one of the Conflict policies.
+func ValidConflictPolicy(policy string) bool {
+	switch policy {
+	case ConflictSrc, ConflictDst, ConflictHigher, ConflictInteractive:
+		return true
+	}
+	return false
+}
+
+// RatingState is the rating of each pair, by key, after the last two-way sync.
+type RatingState struct {
+	Ratings map[string]int
+}
+
+// RatingState reads the ratings saved by the last two-way sync. It's empty on
+// the first run.
+func (s *StateDb) RatingState() (*RatingState, error) {
+	rows, err := s.db.Query("SELECT key, rating FROM two_way_ratings WHERE src = ? AND dst = ?", s.src, s.dst)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := &RatingState{Ratings: make(map[string]int)}
+	for rows.Next() {
+		var key string
+		var rating int
+		if err := rows.Scan(&key, &rating); err != nil {
+			return nil, err
+		}
+		state.Ratings[key] = rating
+	}
+	return state, rows.Err()
+}
+
+// SaveRatingState replaces the ratings saved for the next two-way sync with
+// those of state.
+func (s *StateDb) SaveRatingState(state *RatingState) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM two_way_ratings WHERE src = ? AND dst = ?", s.src, s.dst); err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare("INSERT INTO two_way_ratings (src, dst, key, rating) VALUES (?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for key, rating := range state.Ratings {
+			if _, err := stmt.Exec(s.src, s.dst, key, rating); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RatingChange is a rating to copy between the libraries of a pair during a
+// two-way sync.
+type RatingChange struct {
+	Pair *SongPair
+	// Last is the rating after the last sync, if Known.
+	Last  int
+	Known bool
+	// Conflict is set if both ratings changed since the last sync, or they
+	// differ and there was no last sync. An unrated side is no conflict
+	// without CopyUnrated.
+	Conflict bool
+	// ToSrc is set if the dst rating is copied to src, otherwise the src
+	// rating is copied to dst. Unresolved if it's a conflict the policy
+	// couldn't decide.
+	ToSrc      bool
+	Unresolved bool
+}
+
+// Rating returns the rating being copied.
+func (c *RatingChange) Rating() int {
+	if c.ToSrc {
+		return c.Pair.Dst.FiveStarRating()
+	}
+	return c.Pair.Src.FiveStarRating()
+}
+
+// ConflictPrompt asks which rating to keep for the conflicting c. Returns
+// false to keep neither.
+type ConflictPrompt func(c *RatingChange) (toSrc bool, ok bool)
+
+// PlanTwoWayRatings returns the rating changes that bring the matched pairs of
+// p in line, copying whichever side changed since the state was saved.
+// Conflicts are resolved by policy, asking prompt for ConflictInteractive.
+// Pairs already in line are recorded in state. An unrated side is only copied
+// with copyUnrated, otherwise the rated side wins, e.g., on the first run.
+func PlanTwoWayRatings(p *Pairing, state *RatingState, policy string, copyUnrated bool, prompt ConflictPrompt) []*RatingChange {
+	var changes []*RatingChange
+	for _, v := range p.Matched() {
+		src, dst := v.Src.FiveStarRating(), v.Dst.FiveStarRating()
+		if src == dst {
+			state.Ratings[v.Key] = src
+			continue
+		}
+
+		c := &RatingChange{Pair: v}
+		c.Last, c.Known = state.Ratings[v.Key]
+		switch {
+		case c.Known && dst == c.Last:
+			// Only src changed.
+		case c.Known && src == c.Last:
+			c.ToSrc = true
+		case !copyUnrated && (src == 0 || dst == 0):
+			c.ToSrc = src == 0
+		default:
+			c.Conflict = true
+			switch policy {
+			case ConflictDst:
+				c.ToSrc = true
+			case ConflictHigher:
+				c.ToSrc = dst > src
+			case ConflictInteractive:
+				ok := false
+				if prompt != nil {
+					c.ToSrc, ok = prompt(c)
+				}
+				c.Unresolved = !ok
+			}
+		}
+		if c.Rating() == 0 && !copyUnrated && !c.Unresolved {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// NewConflictPrompt returns a ConflictPrompt that asks on w and reads the
+// answer from r.
+func NewConflictPrompt(w io.Writer, r io.Reader) ConflictPrompt {
+	in := bufio.NewReader(r)
+	return func(c *RatingChange) (bool, bool) {
+		last := "never synced"
+		if c.Known {
+			last = fmt.Sprintf("was %d", c.Last)
+		}
+		fmt.Fprintf(w, "Conflict: %s %s, now src(%d) dst(%d). Keep [s]rc, [d]st or [n]either? ", c.Pair.Key, last, c.Pair.Src.FiveStarRating(), c.Pair.Dst.FiveStarRating())
+		answer, _ := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "s", "src":
+			return false, true
+		case "d", "dst":
+			return true, true
+		}
+		return false, false
+	}
+}
+
+// PrintTwoWayRatings writes the "Two-Way Ratings" section of the report.
+func PrintTwoWayRatings(w io.Writer, changes []*RatingChange) {
+	fmt.Fprintln(w, "== Two-Way Ratings ==")
+	for _, c := range changes {
+		last := "unknown"
+		if c.Known {
+			last = fmt.Sprint(c.Last)
+		}
+		action := "copy to dst"
+		switch {
+		case c.Unresolved:
+			action = "conflict, unresolved"
+		case c.Conflict && c.ToSrc:
+			action = "conflict, copy to src"
+		case c.Conflict:
+			action = "conflict, copy to dst"
+		case c.ToSrc:
+			action = "copy to src"
+		}
+		fmt.Fprintf(w, "%s\n\trating src(%d)\tdst(%d)\tlast(%s)\t%s\n", c.Pair.Key, c.Pair.Src.FiveStarRating(), c.Pair.Dst.FiveStarRating(), last, action)
+	}
+	fmt.Fprintln(w, "")
+}
+
+// CopyRatingChanges sets the rating of each of the resolved changes on src or
+// dst, recording it in state once set.
+func CopyRatingChanges(src, dst RatingSetter, changes []*RatingChange, state *RatingState, skip *SkipCounter) error {
+	bar := PbWithOptions(pb.Default(int64(len(changes)), "set rating"))
+	defer bar.Finish()
+	for _, c := range changes {
+		bar.Add(1)
+		if c.Unresolved {
+			continue
+		}
+		var err error
+		if c.ToSrc {
+			err = src.SetRating(c.Pair.Src.Id(), c.Rating())
+		} else {
+			err = dst.SetRating(c.Pair.Dst.Id(), c.Rating())
+		}
+		if err != nil {
+			if err := skip.Skip(c.Pair.Key, "rating", err); err != nil {
+				return err
+			}
+			continue
+		}
+		state.Ratings[c.Pair.Key] = c.Rating()
+	}
+	return nil
+}
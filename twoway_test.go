@@ -0,0 +1,163 @@
+package itunes2subsonic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPlanTwoWayRatings(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", "/a/same.mp3", 3},
+		testSong{"2", "/a/src_changed.mp3", 5},
+		testSong{"3", "/a/dst_changed.mp3", 2},
+		testSong{"4", "/a/both_changed.mp3", 4},
+		testSong{"5", "/a/never_synced.mp3", 1},
+	}
+	dst := []SongInfo{
+		testSong{"a", "/b/same.mp3", 3},
+		testSong{"b", "/b/src_changed.mp3", 2},
+		testSong{"c", "/b/dst_changed.mp3", 4},
+		testSong{"d", "/b/both_changed.mp3", 5},
+		testSong{"e", "/b/never_synced.mp3", 2},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+	last := map[string]int{"same.mp3": 1, "src_changed.mp3": 2, "dst_changed.mp3": 2, "both_changed.mp3": 3}
+
+	// describe maps each change's key to the rating it copies and where.
+	describe := func(changes []*RatingChange) map[string]string {
+		r := make(map[string]string)
+		for _, c := range changes {
+			switch {
+			case c.Unresolved:
+				r[c.Pair.Key] = "unresolved"
+			case c.ToSrc:
+				r[c.Pair.Key] = "src=" + strconv.Itoa(c.Rating())
+			default:
+				r[c.Pair.Key] = "dst=" + strconv.Itoa(c.Rating())
+			}
+		}
+		return r
+	}
+
+	tests := []struct {
+		policy string
+		answer string
+		want   map[string]string
+	}{
+		{ConflictSrc, "", map[string]string{"src_changed.mp3": "dst=5", "dst_changed.mp3": "src=4", "both_changed.mp3": "dst=4", "never_synced.mp3": "dst=1"}},
+		{ConflictDst, "", map[string]string{"src_changed.mp3": "dst=5", "dst_changed.mp3": "src=4", "both_changed.mp3": "src=5", "never_synced.mp3": "src=2"}},
+		{ConflictHigher, "", map[string]string{"src_changed.mp3": "dst=5", "dst_changed.mp3": "src=4", "both_changed.mp3": "src=5", "never_synced.mp3": "src=2"}},
+		{ConflictInteractive, "s\nneither\n", map[string]string{"src_changed.mp3": "dst=5", "dst_changed.mp3": "src=4", "both_changed.mp3": "dst=4", "never_synced.mp3": "unresolved"}},
+	}
+	for _, test := range tests {
+		state := &RatingState{Ratings: make(map[string]int)}
+		for k, v := range last {
+			state.Ratings[k] = v
+		}
+		prompt := NewConflictPrompt(ioutil.Discard, strings.NewReader(test.answer))
+		got := describe(PlanTwoWayRatings(p, state, test.policy, false, prompt))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: PlanTwoWayRatings() = %v, want %v", test.policy, got, test.want)
+		}
+		if state.Ratings["same.mp3"] != 3 {
+			t.Errorf("%s: state for same.mp3 = %d, want 3", test.policy, state.Ratings["same.mp3"])
+		}
+	}
+
+	// Without a prompt, as in a dry run, conflicts are left alone.
+	state := &RatingState{Ratings: make(map[string]int)}
+	got := describe(PlanTwoWayRatings(p, state, ConflictInteractive, false, nil))
+	if got["both_changed.mp3"] != "unresolved" || got["src_changed.mp3"] != "unresolved" {
+		t.Errorf("PlanTwoWayRatings() without prompt = %v, want unresolved", got)
+	}
+}
+
+func TestPlanTwoWayRatingsUnrated(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", "/a/src_unrated.mp3", 0},
+		testSong{"2", "/a/dst_unrated.mp3", 4},
+		testSong{"3", "/a/src_cleared.mp3", 0},
+	}
+	dst := []SongInfo{
+		testSong{"a", "/b/src_unrated.mp3", 3},
+		testSong{"b", "/b/dst_unrated.mp3", 0},
+		testSong{"c", "/b/src_cleared.mp3", 2},
+	}
+	p := NewPairing(src, dst, PairingOptions{Roots: []RootMapping{{"/a/", "/b/"}}})
+
+	// describe maps each change's key to the rating it copies and where.
+	describe := func(changes []*RatingChange) map[string]string {
+		r := make(map[string]string)
+		for _, c := range changes {
+			if c.ToSrc {
+				r[c.Pair.Key] = "src=" + strconv.Itoa(c.Rating())
+			} else {
+				r[c.Pair.Key] = "dst=" + strconv.Itoa(c.Rating())
+			}
+		}
+		return r
+	}
+
+	// On the first run, the default --conflicts=src doesn't clear dst.
+	state := &RatingState{Ratings: map[string]int{"src_cleared.mp3": 2}}
+	got := describe(PlanTwoWayRatings(p, state, ConflictSrc, false, nil))
+	want := map[string]string{"src_unrated.mp3": "src=3", "dst_unrated.mp3": "dst=4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanTwoWayRatings() = %v, want %v", got, want)
+	}
+
+	state = &RatingState{Ratings: map[string]int{"src_cleared.mp3": 2}}
+	got = describe(PlanTwoWayRatings(p, state, ConflictSrc, true, nil))
+	want = map[string]string{"src_unrated.mp3": "dst=0", "dst_unrated.mp3": "dst=4", "src_cleared.mp3": "dst=0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanTwoWayRatings() with copyUnrated = %v, want %v", got, want)
+	}
+}
+
+func TestRatingState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.db")
+
+	db, err := OpenStateDb(path, "itunes:lib.xml", "subsonic:http://example.com")
+	if err != nil {
+		t.Fatalf("OpenStateDb() failed: %s", err)
+	}
+	defer db.Close()
+	s, err := db.RatingState()
+	if err != nil || len(s.Ratings) != 0 {
+		t.Fatalf("RatingState() of new database = %+v, %v, want empty", s, err)
+	}
+	s.Ratings["rush/2112.mp3"] = 5
+	s.Ratings["rush/gone.mp3"] = 1
+	if err := db.SaveRatingState(s); err != nil {
+		t.Fatalf("SaveRatingState() failed: %s", err)
+	}
+	delete(s.Ratings, "rush/gone.mp3")
+	if err := db.SaveRatingState(s); err != nil {
+		t.Fatalf("SaveRatingState() again failed: %s", err)
+	}
+
+	s, err = db.RatingState()
+	if err != nil || !reflect.DeepEqual(s.Ratings, map[string]int{"rush/2112.mp3": 5}) {
+		t.Errorf("RatingState() = %+v, %v", s, err)
+	}
+
+	// Each pair of libraries has its own state.
+	other, err := OpenStateDb(path, "itunes:lib.xml", "subsonic:http://other.example.com")
+	if err != nil {
+		t.Fatalf("OpenStateDb() again failed: %s", err)
+	}
+	defer other.Close()
+	if s, err := other.RatingState(); err != nil || len(s.Ratings) != 0 {
+		t.Errorf("RatingState() of other dst = %+v, %v, want empty", s, err)
+	}
+}
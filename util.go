@@ -1,12 +1,15 @@
 package itunes2subsonic
 
 import (
-	"math/rand"
-	"os"
-	"path/filepath"
+	"errors"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
 	"strings"
 
 	pb "github.com/schollz/progressbar/v3"
+	"golang.org/x/text/unicode/norm"
 )
 
 // PbWithOptions applies options to a progressbar. I like the default, but the
@@ -16,14 +19,56 @@ func PbWithOptions(p *pb.ProgressBar) *pb.ProgressBar {
 	return p
 }
 
+// SqlQuote quotes s as an SQL string literal for the scripts written for
+// Navidrome and Ampache.
+func SqlQuote(s string) (string, error) {
+	if strings.ContainsRune(s, 0) {
+		return "", errors.New("unexpected NUL in SQL string")
+	}
+	return "'" + strings.Replace(s, "'", "''", -1) + "'", nil
+}
+
+// urlHost matches the scheme and host of a file URL, e.g., "file://localhost".
+var urlHost = regexp.MustCompile(`^(?i)file://[^/]*`)
+
+// urlDrive matches a Windows drive as written in a file URL, e.g., "/M:/".
+var urlDrive = regexp.MustCompile(`^/[A-Za-z]:/`)
+
+// CanonicalPath returns path in a form that compares equal however a library
+// writes the same file:
+//   - File URLs lose the scheme and host, and are unescaped once. In a URL,
+//     '+' is a space, as some exporters escape spaces that way, and "%2B" a
+//     '+'. Other paths keep their '+' and '%'.
+//   - Separators are '/', and a drive loses the leading '/' of a file URL,
+//     so "file://localhost/M:/Music" and `M:\Music` are both "m:/music".
+//   - Unicode is composed (NFC). macOS decomposes file names (NFD).
+//   - Case is folded, as Windows and macOS ignore it. iTunes doesn't notice
+//     if only the case of a file changes either.
+func CanonicalPath(p string) string {
+	if urlHost.MatchString(p) {
+		p = urlHost.ReplaceAllString(p, "")
+		if u, err := url.QueryUnescape(p); err == nil {
+			p = u
+		}
+	}
+	p = strings.Replace(p, "\\", "/", -1)
+	for strings.Contains(p, "//") {
+		p = strings.Replace(p, "//", "/", -1)
+	}
+	if urlDrive.MatchString(p) {
+		p = p[1:]
+	}
+	return strings.ToLower(norm.NFC.String(p))
+}
+
 // longestLibraryPrefix returns the longest prefix that would match the given 2
 // paths assuming they were the same file. If nothing matches, returns the input
 // strings.
 func longestLibraryPrefix(a, b string) (string, string) {
 	var aDir, bDir string
 	for aDir, bDir = a, b; aDir != "" && bDir != ""; {
-		ad, af := filepath.Split(strings.TrimSuffix(aDir, string(os.PathSeparator)))
-		bd, bf := filepath.Split(strings.TrimSuffix(bDir, string(os.PathSeparator)))
+		ad, af := path.Split(strings.TrimSuffix(aDir, "/"))
+		bd, bf := path.Split(strings.TrimSuffix(bDir, "/"))
 
 		if af != bf {
 			break
@@ -35,34 +80,154 @@ func longestLibraryPrefix(a, b string) (string, string) {
 	return aDir, bDir
 }
 
-// LibraryPrefix finds the most likely library root for the given src and dst
-// assuming that both libraries contain mostly the same music.
+// maxPrefixCandidates limits the dst songs compared against a src song with
+// the same file name. Names like "01 Track 01.mp3" say little about the root.
+const maxPrefixCandidates = 20
+
+// PrefixGuess is the library roots detected by DetectLibraryPrefix.
+type PrefixGuess struct {
+	SrcRoot, DstRoot string
+	// Votes is the number of src songs that agree on the roots, out of the
+	// Samples that had a dst song with the same file name.
+	Votes, Samples int
+}
+
+// Confidence returns the share of the samples that agree on the roots, from
+// 0 to 1.
+func (g PrefixGuess) Confidence() float64 {
+	if g.Samples == 0 {
+		return 0
+	}
+	return float64(g.Votes) / float64(g.Samples)
+}
+
+// Mapping returns the roots as a RootMapping.
+func (g PrefixGuess) Mapping() RootMapping {
+	return RootMapping{SrcRoot: g.SrcRoot, DstRoot: g.DstRoot}
+}
+
+// songPaths returns the paths of songs.
+func songPaths(songs []SongInfo) []string {
+	paths := make([]string, len(songs))
+	for i, s := range songs {
+		paths[i] = s.Path()
+	}
+	return paths
+}
+
+// rootCandidates compares each src path to the dst paths with the same file
+// name. Each votes for the roots leaving the longest common path. Returns the
+// roots voted for, most votes first.
 //
-// Note: All paths normalized to lower case.
-func LibraryPrefix(src, dst []SongInfo) (string, string) {
-	// Pick an obviously wrong suffix as an empty string indicates matching libraries.
-	wrong := "zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz"
-	srcPrefix, dstPrefix := wrong, wrong
-
-	// Sample at random as the beginning or end of the libraries may not line up. Pick a limit because this is an expensive scan.
-	for i := 0; i < 500; i++ {
-		// User lower case for systems like Windows where case can change without triggering a library update.
-		srcP := strings.ToLower(src[rand.Intn(len(src))].Path())
-		for _, d := range dst {
-			dstP := strings.ToLower(d.Path())
-			if srcP == "" || dstP == "" {
-				continue
-			}
+// Note: All paths are compared, and roots returned, as CanonicalPath.
+func rootCandidates(src, dst []string) []PrefixGuess {
+	fileName := func(p string) string {
+		_, f := path.Split(p)
+		return f
+	}
 
+	dstByName := make(map[string][]string)
+	for _, d := range dst {
+		if p := CanonicalPath(d); p != "" {
+			dstByName[fileName(p)] = append(dstByName[fileName(p)], p)
+		}
+	}
+
+	votes := make(map[RootMapping]int)
+	samples := 0
+	for _, s := range src {
+		srcP := CanonicalPath(s)
+		candidates := dstByName[fileName(srcP)]
+		if srcP == "" || len(candidates) == 0 || len(candidates) > maxPrefixCandidates {
+			continue
+		}
+		samples++
+
+		// Vote for the roots leaving the longest common path, unless another
+		// candidate leaves one as long.
+		var best RootMapping
+		bestLen, tied := -1, false
+		for _, dstP := range candidates {
 			sp, dp := longestLibraryPrefix(srcP, dstP)
-			if len(sp) < len(srcPrefix) {
-				srcPrefix = sp
-			}
-			if len(dp) < len(dstPrefix) {
-				dstPrefix = dp
+			r := RootMapping{sp, dp}
+			if n := len(srcP) - len(sp); n > bestLen {
+				best, bestLen, tied = r, n, false
+			} else if n == bestLen && r != best {
+				tied = true
 			}
 		}
+		if !tied {
+			votes[best]++
+		}
+	}
+
+	var guesses []PrefixGuess
+	for r, n := range votes {
+		guesses = append(guesses, PrefixGuess{SrcRoot: r.SrcRoot, DstRoot: r.DstRoot, Votes: n, Samples: samples})
+	}
+	// Break ties by name so that the result doesn't depend on map order.
+	sort.Slice(guesses, func(i, j int) bool {
+		a, b := guesses[i], guesses[j]
+		if a.Votes != b.Votes {
+			return a.Votes > b.Votes
+		}
+		if a.SrcRoot != b.SrcRoot {
+			return a.SrcRoot < b.SrcRoot
+		}
+		return a.DstRoot < b.DstRoot
+	})
+	if len(guesses) == 0 {
+		return []PrefixGuess{{Samples: samples}}
+	}
+	return guesses
+}
+
+// DetectLibraryPrefix finds the most likely library root for the given src
+// and dst assuming that both libraries contain mostly the same music. Each
+// src song is compared to the dst songs with the same file name and votes for
+// the roots leaving the longest common path. The roots with the most votes
+// win.
+func DetectLibraryPrefix(src, dst []SongInfo) PrefixGuess {
+	return rootCandidates(songPaths(src), songPaths(dst))[0]
+}
+
+// minRootVotes and minRootShare are how many of the samples must vote for
+// roots other than the most likely for DetectLibraryRoots to keep them, so
+// that a few stray files don't add a root.
+const (
+	minRootVotes = 2
+	minRootShare = 0.05
+)
+
+// DetectLibraryRoots is DetectLibraryPrefix for libraries spanning several
+// folders, e.g., music and podcasts on different drives. Returns the most
+// likely roots first, followed by the others with enough votes. Each src root
+// is only kept once. Returns nil if nothing was voted for.
+func DetectLibraryRoots(src, dst []SongInfo) []PrefixGuess {
+	return detectLibraryRoots(songPaths(src), songPaths(dst))
+}
+
+func detectLibraryRoots(src, dst []string) []PrefixGuess {
+	var r []PrefixGuess
+	seen := make(map[string]bool)
+	for i, g := range rootCandidates(src, dst) {
+		if g.Votes == 0 {
+			break
+		}
+		if i > 0 && (g.Votes < minRootVotes || g.Confidence() < minRootShare) {
+			break
+		}
+		if seen[g.SrcRoot] {
+			continue
+		}
+		seen[g.SrcRoot] = true
+		r = append(r, g)
 	}
+	return r
+}
 
-	return srcPrefix, dstPrefix
+// LibraryPrefix returns the roots found by DetectLibraryPrefix.
+func LibraryPrefix(src, dst []SongInfo) (string, string) {
+	g := DetectLibraryPrefix(src, dst)
+	return g.SrcRoot, g.DstRoot
 }
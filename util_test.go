@@ -1,6 +1,7 @@
 package itunes2subsonic
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -31,3 +32,122 @@ func TestLongestLibraryPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestDetectLibraryPrefix(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", `file://localhost/M:/Music/Rush/2112/01-_2112_.mp3`, 0},
+		testSong{"2", `file://localhost/M:/Music/Rush/2112/02-A_Passage_To_Bangkok.mp3`, 0},
+		testSong{"3", `file://localhost/M:/Music/Rush/2112/03-The_Twilight_Zone.mp3`, 0},
+		testSong{"4", `file://localhost/M:/Music/Rush/Signals/01-Subdivisions.mp3`, 0},
+		testSong{"5", `file://localhost/M:/Music/Other/cover.mp3`, 0},
+		testSong{"6", `file://localhost/M:/Music/Unmatched.mp3`, 0},
+	}
+	dst := []SongInfo{
+		testSong{"a", `/music/Rush/2112/01-_2112_.mp3`, 0},
+		testSong{"b", `/music/Rush/2112/02-A_Passage_To_Bangkok.mp3`, 0},
+		testSong{"c", `/music/Rush/2112/03-The_Twilight_Zone.mp3`, 0},
+		testSong{"d", `/music/Rush/Signals/01-Subdivisions.mp3`, 0},
+		// A stray file outside the library would have won as the shortest.
+		testSong{"e", `/cover.mp3`, 0},
+	}
+
+	want := PrefixGuess{SrcRoot: `m:/`, DstRoot: `/`, Votes: 4, Samples: 5}
+	for i := 0; i < 3; i++ {
+		if got := DetectLibraryPrefix(src, dst); got != want {
+			t.Errorf("DetectLibraryPrefix() = %+v, want %+v", got, want)
+		}
+	}
+	if got := want.Confidence(); got != 0.8 {
+		t.Errorf("Confidence() = %f, want 0.8", got)
+	}
+
+	if got := DetectLibraryPrefix(nil, dst); got != (PrefixGuess{}) || got.Confidence() != 0 {
+		t.Errorf("DetectLibraryPrefix(nil, dst) = %+v, want zero", got)
+	}
+	if got := DetectLibraryPrefix(src, nil); got != (PrefixGuess{}) {
+		t.Errorf("DetectLibraryPrefix(src, nil) = %+v, want zero", got)
+	}
+}
+
+func TestDetectLibraryRoots(t *testing.T) {
+	src := []SongInfo{
+		testSong{"1", `file://localhost/M:/Music/Rush/2112/01-_2112_.mp3`, 0},
+		testSong{"2", `file://localhost/M:/Music/Rush/2112/02-A_Passage_To_Bangkok.mp3`, 0},
+		testSong{"3", `file://localhost/M:/Music/Rush/2112/03-The_Twilight_Zone.mp3`, 0},
+		testSong{"4", `file://localhost/D:/Podcasts/Show/Episode_01.mp3`, 0},
+		testSong{"5", `file://localhost/D:/Podcasts/Show/Episode_02.mp3`, 0},
+		testSong{"6", `file://localhost/M:/Music/Other/cover.mp3`, 0},
+	}
+	dst := []SongInfo{
+		testSong{"a", `/srv/music/Rush/2112/01-_2112_.mp3`, 0},
+		testSong{"b", `/srv/music/Rush/2112/02-A_Passage_To_Bangkok.mp3`, 0},
+		testSong{"c", `/srv/music/Rush/2112/03-The_Twilight_Zone.mp3`, 0},
+		testSong{"d", `/srv/pods/Show/Episode_01.mp3`, 0},
+		testSong{"e", `/srv/pods/Show/Episode_02.mp3`, 0},
+		// A single stray file doesn't make a root.
+		testSong{"f", `/cover.mp3`, 0},
+	}
+
+	want := []PrefixGuess{
+		{SrcRoot: `m:/`, DstRoot: `/srv/`, Votes: 3, Samples: 6},
+		{SrcRoot: `d:/podcasts/`, DstRoot: `/srv/pods/`, Votes: 2, Samples: 6},
+	}
+	if got := DetectLibraryRoots(src, dst); !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectLibraryRoots() = %+v, want %+v", got, want)
+	}
+	if got := DetectLibraryRoots(nil, dst); got != nil {
+		t.Errorf("DetectLibraryRoots(nil, dst) = %+v, want nil", got)
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{`file://localhost/M:/Music/Rush/2112/01-_2112_.mp3`, `m:/music/rush/2112/01-_2112_.mp3`},
+		{`file:///M:/Music/Rush/2112/01-_2112_.mp3`, `m:/music/rush/2112/01-_2112_.mp3`},
+		{`M:\Music\Rush\2112\01-_2112_.mp3`, `m:/music/rush/2112/01-_2112_.mp3`},
+		{`file:///Users/me/Music/Sigur%20R%C3%B3s/Takk.mp3`, `/users/me/music/sigur rós/takk.mp3`},
+		// macOS decomposes the accent (NFD), Linux keeps it composed (NFC).
+		{"/music/Sigur Ro\u0301s/Takk.mp3", "/music/sigur r\u00f3s/takk.mp3"},
+		{"/music/Sigur R\u00f3s/Takk.mp3", "/music/sigur r\u00f3s/takk.mp3"},
+		// Spaces escaped as '+' and '+' escaped as "%2B".
+		{`file://localhost/M:/Music/A+Passage+To+Bangkok.mp3`, `m:/music/a passage to bangkok.mp3`},
+		{`file://localhost/M:/Music/AC%2BDC/Back.mp3`, `m:/music/ac+dc/back.mp3`},
+		// Only URLs are unescaped, and only once.
+		{`/music/AC+DC/Back.mp3`, `/music/ac+dc/back.mp3`},
+		{`M:/Music/100%25 Pure.mp3`, `m:/music/100%25 pure.mp3`},
+		{`file://localhost/M:/Music/100%2525.mp3`, `m:/music/100%25.mp3`},
+		{`file://localhost/M:/Music/100% Pure.mp3`, `m:/music/100% pure.mp3`},
+		{`/music//Rush/2112.mp3`, `/music/rush/2112.mp3`},
+		{``, ``},
+	}
+
+	for _, test := range tests {
+		got := CanonicalPath(test.path)
+		if got != test.want {
+			t.Errorf("CanonicalPath(%s) = '%s', want '%s'", test.path, got, test.want)
+		}
+		if again := CanonicalPath(got); again != got {
+			t.Errorf("CanonicalPath(%s) = '%s', want it unchanged", got, again)
+		}
+	}
+}
+
+func TestPathKey(t *testing.T) {
+	tests := []struct {
+		path, root string
+		want       string
+	}{
+		{`file://localhost/M:/Music/Rush/2112.mp3`, `file://localhost/M:/Music/`, `rush/2112.mp3`},
+		{`M:\Music\Rush\2112.mp3`, `file://localhost/M:/Music/`, `rush/2112.mp3`},
+		{"/Volumes/Music/Sigur Ro\u0301s/Takk.mp3", `/Volumes/Music/`, "sigur r\u00f3s/takk.mp3"},
+		{`/music/Rush/2112.mp3`, `/other/`, `/music/rush/2112.mp3`},
+	}
+
+	for _, test := range tests {
+		if got := PathKey(test.path, test.root); got != test.want {
+			t.Errorf("PathKey(%s, %s) = '%s', want '%s'", test.path, test.root, got, test.want)
+		}
+	}
+}